@@ -0,0 +1,24 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+// Package v1alpha1 contains the TokenRotation API, which reports the state of
+// managed secrets.
+// +kubebuilder:object:generate=true
+// +groupName=metal-token-rotate.ironcore.dev
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/scheme"
+)
+
+var (
+	// GroupVersion is the group and version of the TokenRotation API.
+	GroupVersion = schema.GroupVersion{Group: "metal-token-rotate.ironcore.dev", Version: "v1alpha1"}
+
+	// SchemeBuilder registers the types of this package.
+	SchemeBuilder = &scheme.Builder{GroupVersion: GroupVersion}
+
+	// AddToScheme adds the types of this package to a scheme.
+	AddToScheme = SchemeBuilder.AddToScheme
+)
@@ -0,0 +1,66 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConditionReady is true if the last reconcile of the secret succeeded.
+const ConditionReady = "Ready"
+
+// TokenRotationSpec identifies the managed secret.
+type TokenRotationSpec struct {
+	// SecretName is the name of the managed secret in the same namespace.
+	SecretName string `json:"secretName"`
+	// Identity is the cluster identity of the secret's autoprovision annotation.
+	Identity string `json:"identity"`
+}
+
+// TokenRotationStatus is the state of the tokens in the managed secret.
+type TokenRotationStatus struct {
+	// LastRotationTime is when a token of the secret was last issued.
+	// +optional
+	LastRotationTime *metav1.Time `json:"lastRotationTime,omitempty"`
+	// NextRotationTime is when the secret is reconciled next.
+	// +optional
+	NextRotationTime *metav1.Time `json:"nextRotationTime,omitempty"`
+	// TokenExpiryTime is when the primary token of the secret expires.
+	// +optional
+	TokenExpiryTime *metav1.Time `json:"tokenExpiryTime,omitempty"`
+	// Conditions contain the Ready condition, which carries the error of the
+	// last failed reconcile.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// TokenRotation reports the state of a secret managed by metal-token-rotate.
+// It has the name of the secret and is deleted together with it.
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Identity",type=string,JSONPath=`.spec.identity`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Expiry",type=date,JSONPath=`.status.tokenExpiryTime`
+// +kubebuilder:printcolumn:name="Next Rotation",type=date,JSONPath=`.status.nextRotationTime`
+type TokenRotation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TokenRotationSpec   `json:"spec,omitempty"`
+	Status TokenRotationStatus `json:"status,omitempty"`
+}
+
+// TokenRotationList is a list of TokenRotations.
+// +kubebuilder:object:root=true
+type TokenRotationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TokenRotation `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TokenRotation{}, &TokenRotationList{})
+}
@@ -0,0 +1,60 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// DefaultAnnotationPrefix is the prefix of the annotation keys unless
+// another one is configured.
+const DefaultAnnotationPrefix = "metal.ironcore.dev"
+
+// AnnotationKeys are the keys of the annotations the controller reads and
+// writes on garden secrets.
+type AnnotationKeys struct {
+	Autoprovision       string
+	AutoprovisionPaused string
+	TokenIssuedAt       string
+	TokenExpiresAt      string
+	RotationGeneration  string
+	RenewAfter          string
+}
+
+// DefaultAnnotationKeys are the keys with DefaultAnnotationPrefix.
+var DefaultAnnotationKeys = AnnotationKeys{
+	Autoprovision:       AutoprovisionAnnotationKey,
+	AutoprovisionPaused: AutoprovisionPausedAnnotationKey,
+	TokenIssuedAt:       TokenIssuedAtAnnotationKey,
+	TokenExpiresAt:      TokenExpiresAtAnnotationKey,
+	RotationGeneration:  RotationGenerationAnnotationKey,
+	RenewAfter:          RenewAfterAnnotationKey,
+}
+
+// NewAnnotationKeys returns the annotation keys with prefix, which must be a
+// DNS subdomain like DefaultAnnotationPrefix.
+func NewAnnotationKeys(prefix string) (AnnotationKeys, error) {
+	if errs := validation.IsDNS1123Subdomain(prefix); len(errs) > 0 {
+		return AnnotationKeys{}, fmt.Errorf("invalid annotation prefix %q: %s", prefix, strings.Join(errs, ", "))
+	}
+	return AnnotationKeys{
+		Autoprovision:       prefix + "/autoprovision",
+		AutoprovisionPaused: prefix + "/autoprovision-paused",
+		TokenIssuedAt:       prefix + "/token-issued-at",
+		TokenExpiresAt:      prefix + "/token-expires-at",
+		RotationGeneration:  prefix + "/rotation-generation",
+		RenewAfter:          prefix + "/renew-after",
+	}, nil
+}
+
+// orDefault returns DefaultAnnotationKeys for unset keys.
+func (k AnnotationKeys) orDefault() AnnotationKeys {
+	if k.Autoprovision == "" {
+		return DefaultAnnotationKeys
+	}
+	return k
+}
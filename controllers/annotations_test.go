@@ -0,0 +1,35 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/ironcore-dev/metal-token-rotate/controllers"
+)
+
+var _ = Describe("NewAnnotationKeys", func() {
+
+	It("returns the default keys for the default prefix", func() {
+		Expect(controllers.NewAnnotationKeys(controllers.DefaultAnnotationPrefix)).To(Equal(controllers.DefaultAnnotationKeys))
+	})
+
+	It("derives all keys from the prefix", func() {
+		Expect(controllers.NewAnnotationKeys("tokens.example.com")).To(Equal(controllers.AnnotationKeys{
+			Autoprovision:       "tokens.example.com/autoprovision",
+			AutoprovisionPaused: "tokens.example.com/autoprovision-paused",
+			TokenIssuedAt:       "tokens.example.com/token-issued-at",
+			TokenExpiresAt:      "tokens.example.com/token-expires-at",
+			RotationGeneration:  "tokens.example.com/rotation-generation",
+			RenewAfter:          "tokens.example.com/renew-after",
+		}))
+	})
+
+	It("rejects prefixes that are not DNS subdomains", func() {
+		_, err := controllers.NewAnnotationKeys("Tokens/Example")
+		Expect(err).To(MatchError(ContainSubstring(`invalid annotation prefix "Tokens/Example"`)))
+	})
+
+})
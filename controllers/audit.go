@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// AuditRecord describes an issued token. It never contains the token itself.
+type AuditRecord struct {
+	Time                    time.Time `json:"time"`
+	SecretNamespace         string    `json:"secretNamespace"`
+	SecretName              string    `json:"secretName"`
+	TokenKey                string    `json:"tokenKey"`
+	Identity                string    `json:"identity"`
+	ServiceAccountNamespace string    `json:"serviceAccountNamespace"`
+	ServiceAccountName      string    `json:"serviceAccountName"`
+	Audiences               []string  `json:"audiences,omitempty"`
+	IssuedAt                time.Time `json:"issuedAt"`
+	ExpiresAt               time.Time `json:"expiresAt"`
+}
+
+// AuditSink durably records issued tokens. Implementations must be safe for
+// concurrent use.
+type AuditSink interface {
+	TokenIssued(ctx context.Context, record AuditRecord) error
+}
+
+// JSONLinesAuditSink writes each record as a line of JSON.
+type JSONLinesAuditSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONLinesAuditSink returns a sink writing to w, e.g. os.Stdout.
+func NewJSONLinesAuditSink(w io.Writer) *JSONLinesAuditSink {
+	return &JSONLinesAuditSink{w: w}
+}
+
+// TokenIssued implements AuditSink.
+func (s *JSONLinesAuditSink) TokenIssued(_ context.Context, record AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
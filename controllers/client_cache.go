@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// targetClientCache caches metal clients built from target kubeconfig secrets,
+// so that a client is only rebuilt when its secret changes.
+type targetClientCache struct {
+	mu      sync.Mutex
+	entries map[targetClientKey]targetClientEntry
+}
+
+// targetClientKey identifies a client by its secret and the kubeconfig
+// context it uses, since clusters may use different contexts of one secret.
+type targetClientKey struct {
+	secret      types.NamespacedName
+	contextName string
+}
+
+type targetClientEntry struct {
+	resourceVersion string
+	client          client.Client
+	config          *rest.Config
+}
+
+// get returns the client for the named context of the kubeconfig in the
+// target secret, or for its current-context if contextName is empty. A new
+// client is built if the secret's resourceVersion changed since the last call.
+func (c *targetClientCache) get(ctx context.Context, cl client.Client, targetSecret types.NamespacedName, contextName string) (client.Client, *rest.Config, error) {
+	var secret corev1.Secret
+	if err := cl.Get(ctx, targetSecret, &secret); err != nil {
+		return nil, nil, err
+	}
+	key := targetClientKey{secret: targetSecret, contextName: contextName}
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && entry.resourceVersion == secret.ResourceVersion {
+		return entry.client, entry.config, nil
+	}
+
+	targetClient, config, err := makeTargetClient(&secret, contextName, cl.Scheme())
+	if err != nil {
+		return nil, nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[targetClientKey]targetClientEntry)
+	}
+	c.entries[key] = targetClientEntry{
+		resourceVersion: secret.ResourceVersion,
+		client:          targetClient,
+		config:          config,
+	}
+	return targetClient, config, nil
+}
+
+// invalidate drops the cached clients for all contexts of the target secret.
+func (c *targetClientCache) invalidate(targetSecret types.NamespacedName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if key.secret == targetSecret {
+			delete(c.entries, key)
+		}
+	}
+}
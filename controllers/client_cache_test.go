@@ -0,0 +1,225 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers_test
+
+import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/ironcore-dev/metal-token-rotate/controllers"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: metal
+  cluster:
+    server: https://metal.example.com
+users:
+- name: metal
+  user:
+    token: dummy
+contexts:
+- name: metal
+  context:
+    cluster: metal
+    user: metal
+current-context: metal
+`
+
+var _ = Describe("Target client cache", func() {
+
+	var (
+		targetSecret *corev1.Secret
+		reconciler   *controllers.SecretReconciler
+	)
+
+	BeforeEach(func() {
+		targetSecret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "metal-kubeconfig", Namespace: metav1.NamespaceDefault},
+			Data:       map[string][]byte{"kubeconfig": []byte(testKubeconfig)},
+		}
+		reconciler = &controllers.SecretReconciler{
+			LocalClient: fake.NewClientBuilder().WithObjects(targetSecret).Build(),
+		}
+	})
+
+	It("reuses the client while the target secret is unchanged", func(ctx SpecContext) {
+		first, err := reconciler.TargetClient(ctx, client.ObjectKeyFromObject(targetSecret))
+		Expect(err).ToNot(HaveOccurred())
+		second, err := reconciler.TargetClient(ctx, client.ObjectKeyFromObject(targetSecret))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(second).To(BeIdenticalTo(first))
+	})
+
+	It("rebuilds the client when the target secret changes", func(ctx SpecContext) {
+		first, err := reconciler.TargetClient(ctx, client.ObjectKeyFromObject(targetSecret))
+		Expect(err).ToNot(HaveOccurred())
+
+		targetSecret.Labels = map[string]string{"changed": "true"}
+		Expect(reconciler.LocalClient.Update(ctx, targetSecret)).To(Succeed())
+
+		second, err := reconciler.TargetClient(ctx, client.ObjectKeyFromObject(targetSecret))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(second).ToNot(BeIdenticalTo(first))
+	})
+
+})
+
+var _ = Describe("makeTargetClient", func() {
+
+	DescribeTable("rejects incomplete kubeconfigs",
+		func(replace, with, expectedErr string) {
+			kubeconfig := strings.Replace(testKubeconfig, replace, with, 1)
+			secret := &corev1.Secret{Data: map[string][]byte{"kubeconfig": []byte(kubeconfig)}}
+			_, _, err := controllers.MakeTargetClient(secret, "", clientgoscheme.Scheme)
+			Expect(err).To(MatchError(ContainSubstring("invalid kubeconfig in target secret: " + expectedErr)))
+			Expect(errors.Is(err, reconcile.TerminalError(nil))).To(BeFalse())
+		},
+		Entry("without current-context", "current-context: metal", "", "current-context is not set"),
+		Entry("with an unknown current-context", "current-context: metal", "current-context: other", `current-context "other" is not defined in contexts`),
+		Entry("with an unknown cluster", "    cluster: metal", "    cluster: other", `context "metal" refers to cluster "other", which is not defined in clusters`),
+		Entry("without server", "server: https://metal.example.com", "server: ''", `cluster "metal" has no server`),
+		Entry("with an unknown user", "    user: metal", "    user: other", `context "metal" refers to user "other", which is not defined in users`),
+		Entry("without credentials", "token: dummy", "username: ''", `user "metal" has no credentials`),
+	)
+
+	Describe("with a CA file", func() {
+
+		var caPEM []byte
+
+		BeforeEach(func() {
+			server := httptest.NewTLSServer(http.NotFoundHandler())
+			server.Close()
+			caPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+		})
+
+		kubeconfigWithCAFile := func(path string) []byte {
+			return []byte(strings.Replace(testKubeconfig, "server: https://metal.example.com", "server: https://metal.example.com\n    certificate-authority: "+path, 1))
+		}
+
+		It("takes the CA from the ca.crt key of the secret", func() {
+			secret := &corev1.Secret{Data: map[string][]byte{
+				"kubeconfig": kubeconfigWithCAFile("/home/user/.kube/metal-ca.crt"),
+				"ca.crt":     caPEM,
+			}}
+			_, config, err := controllers.MakeTargetClient(secret, "", clientgoscheme.Scheme)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.CAData).To(Equal(caPEM))
+			Expect(config.CAFile).To(BeEmpty())
+		})
+
+		It("uses CA files that exist in the controller", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "ca.crt")
+			Expect(os.WriteFile(path, caPEM, 0600)).To(Succeed())
+			secret := &corev1.Secret{Data: map[string][]byte{"kubeconfig": kubeconfigWithCAFile(path)}}
+			_, config, err := controllers.MakeTargetClient(secret, "", clientgoscheme.Scheme)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.CAFile).To(Equal(path))
+		})
+
+		It("rejects missing CA files", func() {
+			secret := &corev1.Secret{Data: map[string][]byte{"kubeconfig": kubeconfigWithCAFile("/home/user/.kube/metal-ca.crt")}}
+			_, _, err := controllers.MakeTargetClient(secret, "", clientgoscheme.Scheme)
+			Expect(err).To(MatchError(ContainSubstring(`invalid kubeconfig in target secret: cluster "metal" refers to the CA file /home/user/.kube/metal-ca.crt, which does not exist in the controller, embed the CA as certificate-authority-data or add it to the ca.crt key of the secret`)))
+			Expect(errors.Is(err, reconcile.TerminalError(nil))).To(BeFalse())
+		})
+
+	})
+
+	Describe("with a named context", func() {
+
+		// adds a second context, so that the current-context is not the only one
+		multiContextKubeconfig := strings.Replace(testKubeconfig, "current-context: metal", `- name: other
+  context:
+    cluster: other
+    user: metal
+current-context: other`, 1)
+		multiContextKubeconfig = strings.Replace(multiContextKubeconfig, "users:", `- name: other
+  cluster:
+    server: https://other.example.com
+users:`, 1)
+
+		It("uses the named context instead of the current-context", func() {
+			secret := &corev1.Secret{Data: map[string][]byte{"kubeconfig": []byte(multiContextKubeconfig)}}
+			_, config, err := controllers.MakeTargetClient(secret, "metal", clientgoscheme.Scheme)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Host).To(Equal("https://metal.example.com"))
+			_, config, err = controllers.MakeTargetClient(secret, "", clientgoscheme.Scheme)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Host).To(Equal("https://other.example.com"))
+		})
+
+		It("rejects unknown contexts", func() {
+			secret := &corev1.Secret{Data: map[string][]byte{"kubeconfig": []byte(multiContextKubeconfig)}}
+			_, _, err := controllers.MakeTargetClient(secret, "missing", clientgoscheme.Scheme)
+			Expect(err).To(MatchError(ContainSubstring(`invalid kubeconfig in target secret: context "missing" is not defined in contexts`)))
+		})
+
+	})
+
+	It("authenticates with exec credential plugins", func(ctx SpecContext) {
+		var authorization atomic.Value
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization.Store(r.Header.Get("Authorization"))
+		}))
+		DeferCleanup(server.Close)
+
+		plugin := filepath.Join(GinkgoT().TempDir(), "credential-plugin")
+		Expect(os.WriteFile(plugin, []byte(`#!/bin/sh
+echo '{"apiVersion":"client.authentication.k8s.io/v1","kind":"ExecCredential","status":{"token":"exec-token"}}'
+`), 0755)).To(Succeed())
+		kubeconfig := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: metal
+  cluster:
+    server: %s
+    insecure-skip-tls-verify: true
+users:
+- name: metal
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1
+      command: %s
+      interactiveMode: Never
+contexts:
+- name: metal
+  context:
+    cluster: metal
+    user: metal
+current-context: metal
+`, server.URL, plugin)
+
+		secret := &corev1.Secret{Data: map[string][]byte{"kubeconfig": []byte(kubeconfig)}}
+		_, config, err := controllers.MakeTargetClient(secret, "", clientgoscheme.Scheme)
+		Expect(err).ToNot(HaveOccurred())
+		httpClient, err := rest.HTTPClientFor(config)
+		Expect(err).ToNot(HaveOccurred())
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/version", http.NoBody)
+		Expect(err).ToNot(HaveOccurred())
+		resp, err := httpClient.Do(req)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.Body.Close()).To(Succeed())
+		Expect(authorization.Load()).To(Equal("Bearer exec-token"))
+	})
+
+})
@@ -7,59 +7,667 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"maps"
 	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
 )
 
 const DefaultConfigPath string = "/etc/metal-token-rotate/config.json"
 
+// ConfigAPIVersion and ConfigKind identify the config schema. Configs without
+// them are treated as the current version.
+const (
+	ConfigAPIVersion = "metal-token-rotate.ironcore.dev/v1"
+	ConfigKind       = "Config"
+)
+
 type Config struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+
 	Clusters []ClusterConfig `json:"items"`
+	// DefaultExpirationSeconds applies to clusters without ExpirationSeconds.
+	// Defaults to 3600.
+	DefaultExpirationSeconds int64 `json:"defaultExpirationSeconds"`
+	// MinExpirationSeconds rejects clusters and additional tokens with a
+	// shorter lifetime, which would be rotated almost continuously. Defaults
+	// to 600, the shortest lifetime the API server issues, and can only be
+	// lowered for test setups.
+	MinExpirationSeconds int64 `json:"minExpirationSeconds"`
+	// AllowedServiceAccounts restricts the service accounts tokens are
+	// requested for, independent of the cluster configs. Entries are
+	// "namespace/name" patterns as understood by path.Match, e.g.
+	// "metal-*/token-rotator". All service accounts are allowed if empty.
+	AllowedServiceAccounts []string `json:"allowedServiceAccounts"`
+
+	// clusters by identity, built by LoadConfig
+	byIdentity map[string]ClusterConfig
+}
+
+// Cluster returns the cluster config for identity.
+func (c *Config) Cluster(identity string) (ClusterConfig, bool) {
+	if c.byIdentity == nil {
+		for _, cluster := range c.Clusters {
+			if cluster.Identity == identity {
+				return cluster, true
+			}
+		}
+		return ClusterConfig{}, false
+	}
+	cluster, ok := c.byIdentity[identity]
+	return cluster, ok
 }
 
 type ClusterConfig struct {
-	ServiceAccountName      string `json:"serviceAccountName"`
+	ServiceAccountName string `json:"serviceAccountName"`
+	// ServiceAccountNamespace may be a template, e.g. "{{ .TargetNamespace }}"
+	// to use a service account in the namespace of the autoprovision
+	// annotation. See templateData for the available variables.
 	ServiceAccountNamespace string `json:"serviceAccountNamespace"`
 	ExpirationSeconds       int64  `json:"expirationSeconds"`
-	Identity                string `json:"identity"`
-	TargetSecretName        string `json:"targetSecretName"`
-	TargetSecretNamespace   string `json:"targetSecretNamespace"`
+	// Expiration is an alternative to ExpirationSeconds as a duration like
+	// "24h".
+	Expiration string `json:"expiration"`
+	// MaxExpirationSeconds is the longest token lifetime the metal cluster
+	// issues. Configs requesting longer tokens are rejected. Unlimited if unset.
+	MaxExpirationSeconds int64 `json:"maxExpirationSeconds"`
+	// MaxTokensPerMinute limits the token requests for this identity, e.g. if a
+	// consumer keeps clearing its token. Up to this many tokens can be
+	// requested at once. Unlimited if unset.
+	MaxTokensPerMinute    int64  `json:"maxTokensPerMinute"`
+	Identity              string `json:"identity"`
+	TargetSecretName      string `json:"targetSecretName"`
+	TargetSecretNamespace string `json:"targetSecretNamespace"`
+	// TargetKubeconfigContext selects the context of the kubeconfig in the
+	// target secret. Defaults to its current-context.
+	TargetKubeconfigContext string `json:"targetKubeconfigContext"`
+	// RenewalThresholdPercent is the share of the token lifetime after which
+	// a token is rotated. Defaults to 50.
+	RenewalThresholdPercent int64 `json:"renewalThresholdPercent"`
+	// RenewalJitterPercent shifts the renewal threshold of each secret by up
+	// to this many percentage points in either direction, so that tokens
+	// issued together are not rotated together. The shift is derived from the
+	// UID of the secret, so it is stable across restarts.
+	RenewalJitterPercent int64 `json:"renewalJitterPercent"`
+	// RequeueIntervalSeconds is the longest time between two reconciles of
+	// the secrets of this cluster, and the requeue interval for tokens whose
+	// expiry cannot be determined. Tokens are still rotated at their renewal
+	// threshold. Defaults to one hour and --default-requeue respectively.
+	RequeueIntervalSeconds int64 `json:"requeueIntervalSeconds"`
+	// MaxTokenAge is a duration like "24h" after which tokens are rotated
+	// regardless of their renewal threshold and of the renew-after
+	// annotation, to bound the age of long-lived tokens. Unlimited if unset.
+	MaxTokenAge string `json:"maxTokenAge"`
+	// RotationGeneration rotates the tokens of all secrets of the identity
+	// regardless of their age once it is increased, e.g. after the service
+	// account was compromised. Secrets remember the generation they were
+	// rotated for in an annotation.
+	RotationGeneration int64 `json:"rotationGeneration"`
+	// Audiences are set on issued tokens and checked when reviewing them.
+	// Defaults to the API server audience.
+	Audiences []string `json:"audiences"`
+	// SkipTokenReview decides on rotation by the iat and exp claims of tokens
+	// only and never creates TokenReviews, for metal clusters where the
+	// controller cannot be granted that permission. Revoked tokens are then
+	// only replaced at their renewal threshold.
+	SkipTokenReview bool `json:"skipTokenReview"`
+	// BindToSecret binds issued tokens to the managed secret, so that they are
+	// invalidated once it is deleted. The API server issuing the token resolves
+	// the reference, so the secret has to exist in the service account's
+	// namespace on that cluster.
+	BindToSecret bool `json:"bindToSecret"`
+	// EmitKubeconfig additionally writes a ready-to-use kubeconfig for the
+	// metal cluster into the "kubeconfig" key of the secret.
+	EmitKubeconfig bool `json:"emitKubeconfig"`
+	// EmitCACert additionally writes the CA bundle of the metal cluster into
+	// the "ca.crt" key of the secret, for consumers building their own client.
+	EmitCACert bool `json:"emitCACert"`
+	// VerifyTargetNamespace skips secrets whose target namespace does not
+	// exist in the metal cluster instead of issuing unusable tokens. This
+	// costs an additional request per reconcile.
+	VerifyTargetNamespace bool `json:"verifyTargetNamespace"`
+	// ImpersonateUser and ImpersonateGroups make tokens be reviewed and
+	// requested as this user, so that the metal cluster's audit log attributes
+	// them to it. The controller needs the impersonate permission for them.
+	ImpersonateUser   string   `json:"impersonateUser"`
+	ImpersonateGroups []string `json:"impersonateGroups"`
+	// RestrictToServiceAccountNamespace makes all requests to the metal cluster
+	// in the namespace of the service account, for credentials that are only
+	// granted access there. Namespaces are then not checked for existence.
+	RestrictToServiceAccountNamespace bool `json:"restrictToServiceAccountNamespace"`
+	// CreateServiceAccountIfMissing creates missing service accounts in the
+	// metal cluster instead of waiting for them to be created. This requires
+	// the create permission on service accounts.
+	CreateServiceAccountIfMissing bool `json:"createServiceAccountIfMissing"`
+	// SecretType is the type managed secrets must have. The type of a secret
+	// cannot be changed, so secrets of another type are not modified and
+	// have to be recreated with this type. Any type is accepted if unset.
+	SecretType corev1.SecretType `json:"secretType"`
+	// SecretKeys overrides the keys the token, username and namespace are written to.
+	SecretKeys SecretKeys `json:"secretKeys"`
+	// Base64EncodeTokens writes tokens base64-encoded into the secret data,
+	// for legacy consumers decoding them twice. Tokens are written as is by
+	// default.
+	Base64EncodeTokens bool `json:"base64EncodeTokens"`
+	// Labels are added to managed secrets next to the managed-by label. Other
+	// labels are kept, and labels removed from the config stay on the secrets.
+	Labels map[string]string `json:"labels"`
+	// TokenSinks names additional stores issued tokens are written to before
+	// the secret is patched. The sinks are registered with the controller.
+	TokenSinks []string `json:"tokenSinks"`
+	// AdditionalTokens are minted and rotated independently of the primary
+	// token and written to their own keys of the same secret.
+	AdditionalTokens []TokenConfig `json:"additionalTokens"`
 }
 
+type TokenConfig struct {
+	ServiceAccountName      string `json:"serviceAccountName"`
+	ServiceAccountNamespace string `json:"serviceAccountNamespace"`
+	TokenKey                string `json:"tokenKey"`
+	// ExpirationSeconds defaults to the ExpirationSeconds of the cluster.
+	ExpirationSeconds int64 `json:"expirationSeconds"`
+	// Expiration is an alternative to ExpirationSeconds as a duration.
+	Expiration string `json:"expiration"`
+}
+
+type SecretKeys struct {
+	TokenKey     string `json:"tokenKey"`
+	UsernameKey  string `json:"usernameKey"`
+	NamespaceKey string `json:"namespaceKey"`
+}
+
+// LoadConfig loads the config from path. If path is a directory, all JSON and
+// YAML files in it are loaded in the order of their names and their clusters
+// are merged into a single config.
 func LoadConfig(path string) (Config, error) {
-	data, err := os.ReadFile(path)
+	info, err := os.Stat(path)
 	if err != nil {
 		return Config{}, fmt.Errorf("failed to read config file: %w", err)
 	}
 	var config Config
-	if err := json.Unmarshal(data, &config); err != nil {
-		return Config{}, fmt.Errorf("failed to unmarshal config: %w", err)
+	if info.IsDir() {
+		config, err = loadConfigDir(path)
+	} else {
+		config, err = loadConfigFile(path)
+	}
+	if err != nil {
+		return Config{}, err
+	}
+	return completeConfig(config)
+}
+
+// ParseConfigData parses the keys of a ConfigMap like the files of a config
+// directory: all keys ending in .json, .yaml or .yml are parsed in the order
+// of their names and their clusters are merged into a single config.
+func ParseConfigData(data map[string]string) (Config, error) {
+	files := make(map[string][]byte, len(data))
+	for name, content := range data {
+		files[name] = []byte(content)
+	}
+	config, err := mergeConfigFiles(files)
+	if err != nil {
+		return Config{}, err
 	}
+	return completeConfig(config)
+}
+
+// completeConfig defaults and validates a loaded config.
+func completeConfig(config Config) (Config, error) {
 	if len(config.Clusters) == 0 {
 		return Config{}, errors.New("no clusters found in config")
 	}
-	for i, cluster := range config.Clusters {
-		if err := validateCluster(&cluster); err != nil {
+	if config.DefaultExpirationSeconds <= 0 {
+		config.DefaultExpirationSeconds = 3600
+	}
+	if config.MinExpirationSeconds <= 0 {
+		config.MinExpirationSeconds = defaultMinExpirationSeconds
+	}
+	for _, pattern := range config.AllowedServiceAccounts {
+		if err := validateServiceAccountPattern(pattern); err != nil {
+			return Config{}, err
+		}
+	}
+	identities := make(map[string]int)
+	config.byIdentity = make(map[string]ClusterConfig, len(config.Clusters))
+	for i := range config.Clusters {
+		if err := validateCluster(&config.Clusters[i], config.DefaultExpirationSeconds, config.MinExpirationSeconds); err != nil {
 			return Config{}, fmt.Errorf("invalid cluster at index %d: %w", i, err)
 		}
+		identity := config.Clusters[i].Identity
+		if j, ok := identities[identity]; ok {
+			return Config{}, fmt.Errorf("invalid cluster at index %d: identity %q is already used by cluster at index %d", i, identity, j)
+		}
+		identities[identity] = i
+		config.byIdentity[identity] = config.Clusters[i]
 	}
 	return config, nil
 }
 
-func validateCluster(cluster *ClusterConfig) error {
+func loadConfigFile(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read config file: %w", err)
+	}
+	return parseConfigFile(path, data)
+}
+
+// parseConfigFile parses data as JSON or YAML depending on the extension of name.
+func parseConfigFile(name string, data []byte) (Config, error) {
+	var config Config
+	if err := unmarshalConfig(name, data, &config); err != nil {
+		return Config{}, fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+	if err := validateVersion(&config); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// loadConfigDir merges the config files in dir. Hidden entries are skipped,
+// which excludes the bookkeeping entries of ConfigMap volumes.
+func loadConfigDir(dir string) (Config, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read config directory: %w", err)
+	}
+	files := make(map[string][]byte)
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || !isConfigFile(name) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return Config{}, fmt.Errorf("%s: failed to read config file: %w", name, err)
+		}
+		files[name] = data
+	}
+	return mergeConfigFiles(files)
+}
+
+// mergeConfigFiles parses the config files by name in the order of their
+// names and merges their clusters. Hidden and non-config files are skipped.
+func mergeConfigFiles(files map[string][]byte) (Config, error) {
+	config := Config{APIVersion: ConfigAPIVersion, Kind: ConfigKind}
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		if strings.HasPrefix(name, ".") || !isConfigFile(name) {
+			continue
+		}
+		fragment, err := parseConfigFile(name, files[name])
+		if err != nil {
+			return Config{}, fmt.Errorf("%s: %w", name, err)
+		}
+		if fragment.DefaultExpirationSeconds != 0 {
+			if config.DefaultExpirationSeconds != 0 && config.DefaultExpirationSeconds != fragment.DefaultExpirationSeconds {
+				return Config{}, fmt.Errorf("%s: defaultExpirationSeconds %d conflicts with %d set in another file",
+					name, fragment.DefaultExpirationSeconds, config.DefaultExpirationSeconds)
+			}
+			config.DefaultExpirationSeconds = fragment.DefaultExpirationSeconds
+		}
+		if fragment.MinExpirationSeconds != 0 {
+			if config.MinExpirationSeconds != 0 && config.MinExpirationSeconds != fragment.MinExpirationSeconds {
+				return Config{}, fmt.Errorf("%s: minExpirationSeconds %d conflicts with %d set in another file",
+					name, fragment.MinExpirationSeconds, config.MinExpirationSeconds)
+			}
+			config.MinExpirationSeconds = fragment.MinExpirationSeconds
+		}
+		config.Clusters = append(config.Clusters, fragment.Clusters...)
+		config.AllowedServiceAccounts = append(config.AllowedServiceAccounts, fragment.AllowedServiceAccounts...)
+	}
+	return config, nil
+}
+
+func isConfigFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json", ".yaml", ".yml":
+		return true
+	default:
+		return false
+	}
+}
+
+// Warnings returns likely mistakes in a valid config, like several clusters
+// minting tokens for the same service account.
+func (c *Config) Warnings() []string {
+	var warnings []string
+	serviceAccounts := make(map[types.NamespacedName]int)
+	for i, cluster := range c.Clusters {
+		serviceAccount := types.NamespacedName{Name: cluster.ServiceAccountName, Namespace: cluster.ServiceAccountNamespace}
+		if !isTemplate(serviceAccount.Namespace) && !serviceAccountAllowed(c.AllowedServiceAccounts, serviceAccount) {
+			warnings = append(warnings, fmt.Sprintf("cluster at index %d uses service account %s, which is not in allowedServiceAccounts", i, serviceAccount))
+		}
+		if j, ok := serviceAccounts[serviceAccount]; ok {
+			warnings = append(warnings, fmt.Sprintf("clusters at index %d and %d use the same service account %s", j, i, serviceAccount))
+			continue
+		}
+		serviceAccounts[serviceAccount] = i
+	}
+	return warnings
+}
+
+func validateServiceAccountPattern(pattern string) error {
+	namespace, name, ok := strings.Cut(pattern, "/")
+	if !ok || namespace == "" || name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("invalid allowedServiceAccounts entry %q, expected namespace/name", pattern)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid allowedServiceAccounts entry %q: %w", pattern, err)
+	}
+	return nil
+}
+
+// serviceAccountAllowed reports whether serviceAccount matches one of
+// patterns. Empty patterns allow all service accounts.
+func serviceAccountAllowed(patterns []string, serviceAccount types.NamespacedName) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		// patterns are validated by LoadConfig
+		if ok, _ := path.Match(pattern, serviceAccount.String()); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// unmarshalConfig decodes YAML for .yaml/.yml files and JSON otherwise.
+func unmarshalConfig(path string, data []byte, config *Config) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return yaml.Unmarshal(data, config)
+	default:
+		return json.Unmarshal(data, config)
+	}
+}
+
+// validateVersion defaults apiVersion and kind and rejects configs written for
+// a schema this version of the controller does not know.
+func validateVersion(config *Config) error {
+	if config.APIVersion == "" {
+		config.APIVersion = ConfigAPIVersion
+	}
+	if config.APIVersion != ConfigAPIVersion {
+		return fmt.Errorf("unsupported config apiVersion %q, expected %q", config.APIVersion, ConfigAPIVersion)
+	}
+	if config.Kind == "" {
+		config.Kind = ConfigKind
+	}
+	if config.Kind != ConfigKind {
+		return fmt.Errorf("unsupported config kind %q, expected %q", config.Kind, ConfigKind)
+	}
+	return nil
+}
+
+func validateCluster(cluster *ClusterConfig, defaultExpirationSeconds, minExpirationSeconds int64) error {
 	if cluster.ServiceAccountName == "" {
 		return errors.New("serviceAccountName is required")
 	}
 	if cluster.ServiceAccountNamespace == "" {
 		return errors.New("serviceAccountNamespace is required")
 	}
+	if err := validateNamespaceTemplate(cluster.ServiceAccountNamespace); err != nil {
+		return fmt.Errorf("invalid serviceAccountNamespace: %w", err)
+	}
+	seconds, err := resolveExpiration(cluster.Expiration, cluster.ExpirationSeconds)
+	if err != nil {
+		return err
+	}
+	cluster.ExpirationSeconds = seconds
 	if cluster.ExpirationSeconds <= 0 {
-		cluster.ExpirationSeconds = 3600
+		cluster.ExpirationSeconds = defaultExpirationSeconds
+	}
+	if cluster.ExpirationSeconds < minExpirationSeconds {
+		return fmt.Errorf("expirationSeconds %d is shorter than minExpirationSeconds %d", cluster.ExpirationSeconds, minExpirationSeconds)
+	}
+	if cluster.MaxExpirationSeconds < 0 {
+		return errors.New("maxExpirationSeconds must not be negative")
+	}
+	if cluster.RequeueIntervalSeconds < 0 {
+		return errors.New("requeueIntervalSeconds must not be negative")
+	}
+	if cluster.RequeueIntervalSeconds > 0 && time.Duration(cluster.RequeueIntervalSeconds)*time.Second < minRequeueAfter {
+		return fmt.Errorf("requeueIntervalSeconds %d is shorter than the minimum of %s", cluster.RequeueIntervalSeconds, minRequeueAfter)
+	}
+	if cluster.MaxTokenAge != "" {
+		maxAge, err := time.ParseDuration(cluster.MaxTokenAge)
+		if err != nil {
+			return fmt.Errorf("invalid maxTokenAge: %w", err)
+		}
+		if maxAge < time.Duration(minExpirationSeconds)*time.Second {
+			return fmt.Errorf("maxTokenAge %s is shorter than minExpirationSeconds %d", maxAge, minExpirationSeconds)
+		}
+	}
+	if cluster.RotationGeneration < 0 {
+		return errors.New("rotationGeneration must not be negative")
+	}
+	if cluster.MaxTokensPerMinute < 0 {
+		return errors.New("maxTokensPerMinute must not be negative")
+	}
+	if cluster.MaxExpirationSeconds > 0 && cluster.ExpirationSeconds > cluster.MaxExpirationSeconds {
+		return fmt.Errorf("expirationSeconds %d exceeds maxExpirationSeconds %d", cluster.ExpirationSeconds, cluster.MaxExpirationSeconds)
+	}
+	if cluster.RenewalThresholdPercent == 0 {
+		cluster.RenewalThresholdPercent = 50
+	}
+	if cluster.RenewalThresholdPercent < 1 || cluster.RenewalThresholdPercent > 99 {
+		return errors.New("renewalThresholdPercent must be between 1 and 99")
+	}
+	if cluster.RenewalJitterPercent < 0 {
+		return errors.New("renewalJitterPercent must not be negative")
+	}
+	if cluster.RenewalThresholdPercent-cluster.RenewalJitterPercent < 1 || cluster.RenewalThresholdPercent+cluster.RenewalJitterPercent > 99 {
+		return fmt.Errorf("renewalThresholdPercent %d plus or minus renewalJitterPercent %d must stay between 1 and 99",
+			cluster.RenewalThresholdPercent, cluster.RenewalJitterPercent)
 	}
 	if cluster.Identity == "" {
 		return errors.New("identity is required")
 	}
+	if cluster.SecretKeys.TokenKey == "" {
+		cluster.SecretKeys.TokenKey = "token"
+	}
+	if cluster.SecretKeys.UsernameKey == "" {
+		cluster.SecretKeys.UsernameKey = "username"
+	}
+	if cluster.SecretKeys.NamespaceKey == "" {
+		cluster.SecretKeys.NamespaceKey = "namespace"
+	}
+	for _, audience := range cluster.Audiences {
+		if audience == "" {
+			return errors.New("audiences must not contain empty entries")
+		}
+	}
+	for _, sink := range cluster.TokenSinks {
+		if sink == "" {
+			return errors.New("tokenSinks must not contain empty entries")
+		}
+	}
+	if err := validateLabels(cluster.Labels); err != nil {
+		return err
+	}
+	if cluster.ImpersonateUser == "" && len(cluster.ImpersonateGroups) > 0 {
+		return errors.New("impersonateGroups requires impersonateUser")
+	}
+	if cluster.RestrictToServiceAccountNamespace && cluster.VerifyTargetNamespace {
+		return errors.New("verifyTargetNamespace needs access to namespaces and cannot be combined with restrictToServiceAccountNamespace")
+	}
 	if (cluster.TargetSecretName == "") != (cluster.TargetSecretNamespace == "") {
 		return errors.New("both TargetSecretName and TargetSecretNamespace must be set or unset together")
 	}
+	if cluster.TargetKubeconfigContext != "" && cluster.TargetSecretName == "" {
+		return errors.New("targetKubeconfigContext requires targetSecretName and targetSecretNamespace")
+	}
+	usedKeys := map[string]bool{
+		cluster.SecretKeys.TokenKey:     true,
+		cluster.SecretKeys.UsernameKey:  true,
+		cluster.SecretKeys.NamespaceKey: true,
+	}
+	for i := range cluster.AdditionalTokens {
+		token := &cluster.AdditionalTokens[i]
+		if token.ServiceAccountName == "" || token.ServiceAccountNamespace == "" {
+			return fmt.Errorf("additional token %d: serviceAccountName and serviceAccountNamespace are required", i)
+		}
+		if err := validateNamespaceTemplate(token.ServiceAccountNamespace); err != nil {
+			return fmt.Errorf("additional token %d: invalid serviceAccountNamespace: %w", i, err)
+		}
+		if cluster.RestrictToServiceAccountNamespace && token.ServiceAccountNamespace != cluster.ServiceAccountNamespace {
+			return fmt.Errorf("additional token %d: serviceAccountNamespace must be %q with restrictToServiceAccountNamespace", i, cluster.ServiceAccountNamespace)
+		}
+		if token.TokenKey == "" {
+			return fmt.Errorf("additional token %d: tokenKey is required", i)
+		}
+		if usedKeys[token.TokenKey] {
+			return fmt.Errorf("additional token %d: key %q is already used", i, token.TokenKey)
+		}
+		usedKeys[token.TokenKey] = true
+		seconds, err := resolveExpiration(token.Expiration, token.ExpirationSeconds)
+		if err != nil {
+			return fmt.Errorf("additional token %d: %w", i, err)
+		}
+		token.ExpirationSeconds = seconds
+		if token.ExpirationSeconds <= 0 {
+			token.ExpirationSeconds = cluster.ExpirationSeconds
+		}
+		if token.ExpirationSeconds < minExpirationSeconds {
+			return fmt.Errorf("additional token %d: expirationSeconds %d is shorter than minExpirationSeconds %d", i, token.ExpirationSeconds, minExpirationSeconds)
+		}
+		if cluster.MaxExpirationSeconds > 0 && token.ExpirationSeconds > cluster.MaxExpirationSeconds {
+			return fmt.Errorf("additional token %d: expirationSeconds %d exceeds maxExpirationSeconds %d", i, token.ExpirationSeconds, cluster.MaxExpirationSeconds)
+		}
+	}
 	return nil
 }
+
+func validateLabels(labels map[string]string) error {
+	for _, key := range slices.Sorted(maps.Keys(labels)) {
+		if key == ManagedByLabelKey {
+			return fmt.Errorf("label %s is set by the controller", key)
+		}
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid label key %q: %s", key, strings.Join(errs, ", "))
+		}
+		if errs := validation.IsValidLabelValue(labels[key]); len(errs) > 0 {
+			return fmt.Errorf("invalid value %q of label %s: %s", labels[key], key, strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
+
+// defaultMinExpirationSeconds is the shortest token lifetime the API server issues.
+const defaultMinExpirationSeconds = 600
+
+// resolveExpiration returns the seconds of expiration if it is set, or
+// seconds otherwise.
+func resolveExpiration(expiration string, seconds int64) (int64, error) {
+	if expiration == "" {
+		return seconds, nil
+	}
+	d, err := time.ParseDuration(expiration)
+	if err != nil {
+		return 0, fmt.Errorf("invalid expiration: %w", err)
+	}
+	if d < time.Second {
+		return 0, fmt.Errorf("expiration %s must be at least one second", d)
+	}
+	expirationSeconds := int64(d / time.Second)
+	if seconds != 0 && seconds != expirationSeconds {
+		return 0, fmt.Errorf("expiration %s conflicts with expirationSeconds %d", d, seconds)
+	}
+	return expirationSeconds, nil
+}
+
+func validateNamespaceTemplate(namespace string) error {
+	if !isTemplate(namespace) {
+		return nil
+	}
+	_, err := parseTemplate(namespace)
+	return err
+}
+
+// resolveServiceAccountNamespaces renders templated service account
+// namespaces of c in place and returns the rendered namespaces.
+func (c *ClusterConfig) resolveServiceAccountNamespaces(data templateData) ([]string, error) {
+	var rendered []string
+	resolve := func(namespace *string) error {
+		if !isTemplate(*namespace) {
+			return nil
+		}
+		value, err := renderTemplate(*namespace, data)
+		if err != nil {
+			return err
+		}
+		if err := validateNamespace(value); err != nil {
+			return err
+		}
+		*namespace = value
+		rendered = append(rendered, value)
+		return nil
+	}
+	if err := resolve(&c.ServiceAccountNamespace); err != nil {
+		return nil, fmt.Errorf("invalid serviceAccountNamespace: %w", err)
+	}
+	// do not modify the tokens of the shared config
+	c.AdditionalTokens = slices.Clone(c.AdditionalTokens)
+	for i := range c.AdditionalTokens {
+		if err := resolve(&c.AdditionalTokens[i].ServiceAccountNamespace); err != nil {
+			return nil, fmt.Errorf("additional token %d: invalid serviceAccountNamespace: %w", i, err)
+		}
+	}
+	return rendered, nil
+}
+
+// tokenSpec describes a single token written into a managed secret.
+type tokenSpec struct {
+	serviceAccount    types.NamespacedName
+	expirationSeconds int64
+	key               string
+}
+
+// tokenSpecs returns the primary token followed by the additional tokens.
+func (c *ClusterConfig) tokenSpecs() []tokenSpec {
+	specs := []tokenSpec{{
+		serviceAccount:    types.NamespacedName{Name: c.ServiceAccountName, Namespace: c.ServiceAccountNamespace},
+		expirationSeconds: c.ExpirationSeconds,
+		key:               c.SecretKeys.TokenKey,
+	}}
+	for _, token := range c.AdditionalTokens {
+		specs = append(specs, tokenSpec{
+			serviceAccount:    types.NamespacedName{Name: token.ServiceAccountName, Namespace: token.ServiceAccountNamespace},
+			expirationSeconds: token.ExpirationSeconds,
+			key:               token.TokenKey,
+		})
+	}
+	return specs
+}
+
+// maxTokenAge returns MaxTokenAge, or 0 if it is unset. The config must
+// have been validated.
+func (c *ClusterConfig) maxTokenAge() time.Duration {
+	if c.MaxTokenAge == "" {
+		return 0
+	}
+	maxAge, _ := time.ParseDuration(c.MaxTokenAge)
+	return maxAge
+}
+
+// renewalThresholdPercentFor returns the renewal threshold of the secret with
+// uid, shifted by up to RenewalJitterPercent based on a hash of uid.
+func (c *ClusterConfig) renewalThresholdPercentFor(uid types.UID) int64 {
+	if c.RenewalJitterPercent <= 0 {
+		return c.RenewalThresholdPercent
+	}
+	h := fnv.New64a()
+	h.Write([]byte(uid))
+	shift := int64(h.Sum64()%uint64(2*c.RenewalJitterPercent+1)) - c.RenewalJitterPercent //nolint:gosec // bounded by RenewalJitterPercent
+	return c.RenewalThresholdPercent + shift
+}
@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// configMapRewatchDelay is the pause before a closed or failed ConfigMap
+// watch is set up again.
+const configMapRewatchDelay = 5 * time.Second
+
+// NewConfigMapWatcher loads the initial config from the data of configMap,
+// which is read and watched with c. Like NewConfigWatcher, it fails if the
+// initial config is invalid.
+func NewConfigMapWatcher(ctx context.Context, c client.WithWatch, configMap types.NamespacedName, log logr.Logger) (*ConfigWatcher, error) {
+	w := &ConfigWatcher{ConfigMap: configMap, Client: c, Log: log}
+	if err := w.reload(ctx); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *ConfigWatcher) loadConfigMap(ctx context.Context) (Config, error) {
+	ctx, cancel := context.WithTimeout(ctx, DefaultClientTimeout)
+	defer cancel()
+	var configMap corev1.ConfigMap
+	if err := w.Client.Get(ctx, w.ConfigMap, &configMap); err != nil {
+		return Config{}, fmt.Errorf("failed to read config map %s: %w", w.ConfigMap, err)
+	}
+	w.configMapVersion = configMap.ResourceVersion
+	return ParseConfigData(configMap.Data)
+}
+
+// watchConfigMap reloads the config whenever the ConfigMap changes until ctx
+// is cancelled. Watches resume from the last loaded version, so that no
+// change is missed while a closed watch is set up again.
+func (w *ConfigWatcher) watchConfigMap(ctx context.Context) error {
+	for {
+		err := w.watchConfigMapOnce(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			w.Log.Error(err, "config watcher error", w.source()...)
+			// the last loaded version may be too old to resume from
+			w.reloadAndNotify(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(configMapRewatchDelay):
+		}
+	}
+}
+
+func (w *ConfigWatcher) watchConfigMapOnce(ctx context.Context) error {
+	watcher, err := w.Client.Watch(ctx, &corev1.ConfigMapList{},
+		client.InNamespace(w.ConfigMap.Namespace),
+		client.MatchingFields{"metadata.name": w.ConfigMap.Name},
+		&client.ListOptions{Raw: &metav1.ListOptions{ResourceVersion: w.configMapVersion}})
+	if err != nil {
+		return fmt.Errorf("failed to watch config map %s: %w", w.ConfigMap, err)
+	}
+	defer watcher.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return nil
+			}
+			if event.Type == watch.Error {
+				return fmt.Errorf("failed to watch config map %s: %w", w.ConfigMap, apierrors.FromObject(event.Object))
+			}
+			configMap, ok := event.Object.(*corev1.ConfigMap)
+			if !ok || configMap.Name != w.ConfigMap.Name || configMap.ResourceVersion == w.configMapVersion {
+				continue
+			}
+			w.reloadAndNotify(ctx)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/ironcore-dev/metal-token-rotate/controllers"
+)
+
+var _ = Describe("ConfigWatcher for a ConfigMap", func() {
+
+	const (
+		validConfig   = `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"}]}`
+		updatedConfig = `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-b"}]}`
+	)
+
+	var (
+		key        = types.NamespacedName{Namespace: "garden", Name: "metal-token-rotate"}
+		fakeClient client.WithWatch
+	)
+
+	identities := func(watcher *controllers.ConfigWatcher) []string {
+		var result []string
+		for _, c := range watcher.Config().Clusters {
+			result = append(result, c.Identity)
+		}
+		return result
+	}
+
+	BeforeEach(func() {
+		fakeClient = fake.NewClientBuilder().WithObjects(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+			Data:       map[string]string{"config.json": validConfig},
+		}).Build()
+	})
+
+	It("loads the initial config", func(ctx SpecContext) {
+		watcher, err := controllers.NewConfigMapWatcher(ctx, fakeClient, key, GinkgoLogr)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(identities(watcher)).To(Equal([]string{"cluster-a"}))
+	})
+
+	It("refuses to start without the ConfigMap", func(ctx SpecContext) {
+		_, err := controllers.NewConfigMapWatcher(ctx, fakeClient, types.NamespacedName{Namespace: "garden", Name: "missing"}, GinkgoLogr)
+		Expect(err).To(MatchError(ContainSubstring("failed to read config map garden/missing")))
+	})
+
+	It("refuses to start with an invalid initial config", func(ctx SpecContext) {
+		var configMap corev1.ConfigMap
+		Expect(fakeClient.Get(ctx, key, &configMap)).To(Succeed())
+		configMap.Data = map[string]string{"config.json": `{"items":[]}`}
+		Expect(fakeClient.Update(ctx, &configMap)).To(Succeed())
+		_, err := controllers.NewConfigMapWatcher(ctx, fakeClient, key, GinkgoLogr)
+		Expect(err).To(MatchError("no clusters found in config"))
+	})
+
+	It("reloads and notifies when the ConfigMap changes", func(ctx SpecContext) {
+		watcher, err := controllers.NewConfigMapWatcher(ctx, fakeClient, key, GinkgoLogr)
+		Expect(err).ToNot(HaveOccurred())
+		changes := make(chan struct{}, 1)
+		watcher.Notify(changes)
+		watchCtx, cancel := context.WithCancel(ctx)
+		done := make(chan error)
+		go func() {
+			done <- watcher.Start(watchCtx)
+		}()
+		DeferCleanup(func() {
+			cancel()
+			Expect(<-done).To(Succeed())
+		})
+
+		// updates made before the watch is established are not seen by the fake client
+		Eventually(func(g Gomega) {
+			var configMap corev1.ConfigMap
+			g.Expect(fakeClient.Get(ctx, key, &configMap)).To(Succeed())
+			configMap.Data = map[string]string{"config.json": updatedConfig}
+			g.Expect(fakeClient.Update(ctx, &configMap)).To(Succeed())
+			g.Expect(identities(watcher)).To(Equal([]string{"cluster-b"}))
+		}).Should(Succeed())
+		Expect(changes).To(Receive())
+	})
+
+})
@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"sync/atomic"
+
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// configResync enqueues all annotated secrets for every config reload. It
+// runs as a manager runnable, so that the manager waits for it on shutdown,
+// and gets the controller's queue from its source once the controller starts.
+type configResync struct {
+	r       *SecretReconciler
+	changes chan struct{}
+	queue   atomic.Pointer[workqueue.TypedRateLimitingInterface[reconcile.Request]]
+}
+
+func newConfigResync(r *SecretReconciler) *configResync {
+	c := &configResync{r: r, changes: make(chan struct{}, 1)}
+	r.ConfigWatcher.Notify(c.changes)
+	return c
+}
+
+// source hands the controller's queue to the runnable.
+func (c *configResync) source() source.Source {
+	return source.Func(func(_ context.Context, queue workqueue.TypedRateLimitingInterface[reconcile.Request]) error {
+		c.queue.Store(&queue)
+		return nil
+	})
+}
+
+// Start implements manager.Runnable. It returns once ctx is cancelled.
+func (c *configResync) Start(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-c.changes:
+			// the controller lists all secrets when it starts anyway
+			queue := c.queue.Load()
+			if queue == nil {
+				continue
+			}
+			requests, err := c.r.annotatedSecretRequests(ctx, func(target) bool { return true })
+			if err != nil {
+				c.r.Log.Error(err, "unable to list secrets for changed config")
+				continue
+			}
+			c.r.Log.Info("config changed, reconciling all annotated secrets", "count", len(requests))
+			for _, request := range requests {
+				(*queue).Add(request)
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+
+	"github.com/ironcore-dev/metal-token-rotate/controllers"
+)
+
+var _ = Describe("the background runnables", func() {
+
+	It("stop with the manager without leaking goroutines", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "config.json")
+		Expect(os.WriteFile(path, []byte(`{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"}]}`), 0644)).To(Succeed())
+		configWatcher, err := controllers.NewConfigWatcher(path, GinkgoLogr)
+		Expect(err).ToNot(HaveOccurred())
+		before := runtime.NumGoroutine()
+
+		// nothing is requested from the API server without controllers
+		mgr, err := ctrl.NewManager(&rest.Config{Host: "https://127.0.0.1:1"}, ctrl.Options{
+			Logger:  GinkgoLogr,
+			Metrics: metricsserver.Options{BindAddress: "0"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(mgr.Add(configWatcher)).To(Succeed())
+		r := &controllers.SecretReconciler{Log: GinkgoLogr, ConfigWatcher: configWatcher}
+		Expect(r.AddConfigResync(mgr)).To(Succeed())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- mgr.Start(ctx)
+		}()
+		Expect(os.WriteFile(path, []byte(`{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-b"}]}`), 0644)).To(Succeed())
+		Eventually(func() string {
+			return configWatcher.Config().Clusters[0].Identity
+		}).Should(Equal("cluster-b"))
+
+		cancel()
+		Eventually(done).Should(Receive(Succeed()))
+		Eventually(runtime.NumGoroutine).Should(BeNumerically("<=", before))
+	})
+
+})
@@ -0,0 +1,492 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/ironcore-dev/metal-token-rotate/controllers"
+)
+
+var _ = Describe("LoadConfig", func() {
+
+	writeConfig := func(name, content string) string {
+		path := filepath.Join(GinkgoT().TempDir(), name)
+		Expect(os.WriteFile(path, []byte(content), 0644)).To(Succeed())
+		return path
+	}
+
+	expected := controllers.ClusterConfig{
+		ServiceAccountName:      "sa",
+		ServiceAccountNamespace: "sa-namespace",
+		ExpirationSeconds:       600,
+		Identity:                "cluster-a",
+		RenewalThresholdPercent: 50,
+		SecretKeys: controllers.SecretKeys{
+			TokenKey:     "token",
+			UsernameKey:  "username",
+			NamespaceKey: "namespace",
+		},
+	}
+
+	It("loads a JSON config", func() {
+		path := writeConfig("config.json", `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"sa-namespace","expirationSeconds":600,"identity":"cluster-a"}]}`)
+		config, err := controllers.LoadConfig(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.Clusters).To(ConsistOf(expected))
+	})
+
+	DescribeTable("loads a YAML config",
+		func(name, content string) {
+			config, err := controllers.LoadConfig(writeConfig(name, content))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Clusters).To(ConsistOf(expected))
+		},
+		Entry("with a .yaml extension", "config.yaml", `
+items:
+- serviceAccountName: sa
+  serviceAccountNamespace: sa-namespace
+  expirationSeconds: 600
+  identity: cluster-a
+`),
+		Entry("with a mixed-case .YmL extension", "config.YmL", `
+items:
+- serviceAccountName: sa
+  serviceAccountNamespace: sa-namespace
+  expirationSeconds: 600
+  identity: cluster-a
+`),
+		Entry("with a leading document separator", "config.yml", `---
+items:
+- serviceAccountName: sa
+  serviceAccountNamespace: sa-namespace
+  expirationSeconds: 600
+  identity: cluster-a
+`),
+	)
+
+	It("applies the same validation to YAML configs", func() {
+		_, err := controllers.LoadConfig(writeConfig("config.yaml", `
+items:
+- serviceAccountName: sa
+  expirationSeconds: 600
+  identity: cluster-a
+`))
+		Expect(err).To(MatchError(ContainSubstring("serviceAccountNamespace is required")))
+	})
+
+	DescribeTable("validates renewalThresholdPercent",
+		func(value string, expectedPercent int64, expectedErr string) {
+			config, err := controllers.LoadConfig(writeConfig("config.json", `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"`+value+`}]}`))
+			if expectedErr != "" {
+				Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Clusters[0].RenewalThresholdPercent).To(Equal(expectedPercent))
+		},
+		Entry("defaults to 50", "", int64(50), ""),
+		Entry("accepts 80", `,"renewalThresholdPercent":80`, int64(80), ""),
+		Entry("rejects 100", `,"renewalThresholdPercent":100`, int64(0), "between 1 and 99"),
+		Entry("rejects negative values", `,"renewalThresholdPercent":-5`, int64(0), "between 1 and 99"),
+	)
+
+	DescribeTable("validates restrictToServiceAccountNamespace",
+		func(value, expectedErr string) {
+			_, err := controllers.LoadConfig(writeConfig("config.json", `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a","restrictToServiceAccountNamespace":true`+value+`}]}`))
+			if expectedErr != "" {
+				Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+		},
+		Entry("accepts additional tokens in the same namespace", `,"additionalTokens":[{"serviceAccountName":"sa2","serviceAccountNamespace":"ns","tokenKey":"token2"}]`, ""),
+		Entry("rejects additional tokens in other namespaces", `,"additionalTokens":[{"serviceAccountName":"sa2","serviceAccountNamespace":"other","tokenKey":"token2"}]`, `serviceAccountNamespace must be "ns"`),
+		Entry("rejects verifyTargetNamespace", `,"verifyTargetNamespace":true`, "cannot be combined with restrictToServiceAccountNamespace"),
+	)
+
+	DescribeTable("validates renewalJitterPercent",
+		func(value, expectedErr string) {
+			_, err := controllers.LoadConfig(writeConfig("config.json", `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"`+value+`}]}`))
+			if expectedErr != "" {
+				Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+		},
+		Entry("accepts 5", `,"renewalJitterPercent":5`, ""),
+		Entry("rejects negative values", `,"renewalJitterPercent":-5`, "renewalJitterPercent must not be negative"),
+		Entry("rejects ranges above 99", `,"renewalThresholdPercent":90,"renewalJitterPercent":10`, "must stay between 1 and 99"),
+		Entry("rejects ranges below 1", `,"renewalThresholdPercent":10,"renewalJitterPercent":10`, "must stay between 1 and 99"),
+	)
+
+	Describe("RenewalThresholdPercentFor", func() {
+
+		It("returns the threshold without jitter", func() {
+			cluster := controllers.ClusterConfig{RenewalThresholdPercent: 50}
+			Expect(cluster.RenewalThresholdPercentFor("uid")).To(Equal(int64(50)))
+		})
+
+		It("spreads secrets within the jitter range", func() {
+			cluster := controllers.ClusterConfig{RenewalThresholdPercent: 50, RenewalJitterPercent: 5}
+			thresholds := make(map[int64]bool)
+			for i := range 100 {
+				uid := types.UID(fmt.Sprintf("uid-%d", i))
+				threshold := cluster.RenewalThresholdPercentFor(uid)
+				Expect(threshold).To(BeNumerically(">=", 45))
+				Expect(threshold).To(BeNumerically("<=", 55))
+				Expect(cluster.RenewalThresholdPercentFor(uid)).To(Equal(threshold))
+				thresholds[threshold] = true
+			}
+			Expect(len(thresholds)).To(BeNumerically(">", 5))
+		})
+
+	})
+
+	DescribeTable("defaults expirationSeconds",
+		func(defaultValue, value string, expectedSeconds int64) {
+			config, err := controllers.LoadConfig(writeConfig("config.json", `{`+defaultValue+`"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"`+value+`}]}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Clusters[0].ExpirationSeconds).To(Equal(expectedSeconds))
+		},
+		Entry("to 3600", "", "", int64(3600)),
+		Entry("to the global default", `"defaultExpirationSeconds":7200,`, "", int64(7200)),
+		Entry("to 3600 for an invalid global default", `"defaultExpirationSeconds":-1,`, "", int64(3600)),
+		Entry("not for clusters setting it", `"defaultExpirationSeconds":7200,`, `,"expirationSeconds":600`, int64(600)),
+		Entry("from a duration", "", `,"expiration":"24h"`, int64(86400)),
+		Entry("from a duration matching expirationSeconds", "", `,"expiration":"10m","expirationSeconds":600`, int64(600)),
+	)
+
+	DescribeTable("validates minExpirationSeconds",
+		func(global, value, expectedErr string) {
+			_, err := controllers.LoadConfig(writeConfig("config.json", `{`+global+`"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"`+value+`}]}`))
+			if expectedErr != "" {
+				Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+		},
+		Entry("accepts the floor", "", `,"expirationSeconds":600`, ""),
+		Entry("rejects shorter expirations", "", `,"expirationSeconds":30`, "expirationSeconds 30 is shorter than minExpirationSeconds 600"),
+		Entry("rejects short global defaults", `"defaultExpirationSeconds":300,`, "", "expirationSeconds 300 is shorter than minExpirationSeconds 600"),
+		Entry("rejects short additional tokens", "", `,"additionalTokens":[{"serviceAccountName":"ro","serviceAccountNamespace":"ns","tokenKey":"ro","expirationSeconds":60}]`,
+			"additional token 0: expirationSeconds 60 is shorter than minExpirationSeconds 600"),
+		Entry("accepts short expirations with a lower floor", `"minExpirationSeconds":30,`, `,"expiration":"30s"`, ""),
+		Entry("rejects the default expiration with a higher floor", `"minExpirationSeconds":7200,`, "", "expirationSeconds 3600 is shorter than minExpirationSeconds 7200"),
+	)
+
+	DescribeTable("validates expiration",
+		func(value, expectedErr string) {
+			_, err := controllers.LoadConfig(writeConfig("config.json", `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"`+value+`}]}`))
+			Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+		},
+		Entry("rejects invalid durations", `,"expiration":"one day"`, "invalid expiration"),
+		Entry("rejects negative durations", `,"expiration":"-1h"`, "expiration -1h0m0s must be at least one second"),
+		Entry("rejects short durations", `,"expiration":"5m"`, "expirationSeconds 300 is shorter than minExpirationSeconds 600"),
+		Entry("rejects conflicts with expirationSeconds", `,"expiration":"1h","expirationSeconds":600`, "expiration 1h0m0s conflicts with expirationSeconds 600"),
+		Entry("rejects invalid durations of additional tokens", `,"additionalTokens":[{"serviceAccountName":"ro","serviceAccountNamespace":"ns","tokenKey":"ro","expiration":"1m"}]`, "additional token 0: expirationSeconds 60 is shorter than minExpirationSeconds 600"),
+	)
+
+	DescribeTable("validates requeueIntervalSeconds",
+		func(value, expectedErr string) {
+			_, err := controllers.LoadConfig(writeConfig("config.json", `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a","requeueIntervalSeconds":`+value+`}]}`))
+			if expectedErr != "" {
+				Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+		},
+		Entry("accepts 4 hours", "14400", ""),
+		Entry("accepts the minimum", "30", ""),
+		Entry("rejects shorter intervals", "10", "requeueIntervalSeconds 10 is shorter than the minimum of 30s"),
+		Entry("rejects negative values", "-1", "requeueIntervalSeconds must not be negative"),
+	)
+
+	DescribeTable("validates maxTokenAge",
+		func(value, expectedErr string) {
+			_, err := controllers.LoadConfig(writeConfig("config.json", `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a","maxTokenAge":"`+value+`"}]}`))
+			if expectedErr != "" {
+				Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+		},
+		Entry("accepts 24 hours", "24h", ""),
+		Entry("accepts the minimum expiration", "10m", ""),
+		Entry("rejects shorter ages", "5m", "maxTokenAge 5m0s is shorter than minExpirationSeconds 600"),
+		Entry("rejects invalid durations", "a day", "invalid maxTokenAge"),
+	)
+
+	DescribeTable("validates labels",
+		func(value, expectedErr string) {
+			_, err := controllers.LoadConfig(writeConfig("config.json", `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a","labels":`+value+`}]}`))
+			if expectedErr != "" {
+				Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+		},
+		Entry("accepts prefixed keys", `{"example.com/team":"metal","tier":""}`, ""),
+		Entry("rejects invalid keys", `{"team a":"metal"}`, `invalid label key "team a"`),
+		Entry("rejects invalid values", `{"team":"metal/infra"}`, `invalid value "metal/infra" of label team`),
+		Entry("rejects the managed-by label", `{"app.kubernetes.io/managed-by":"someone-else"}`, "label app.kubernetes.io/managed-by is set by the controller"),
+	)
+
+	DescribeTable("validates maxExpirationSeconds",
+		func(value, expectedErr string) {
+			_, err := controllers.LoadConfig(writeConfig("config.json", `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"`+value+`}]}`))
+			if expectedErr != "" {
+				Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+		},
+		Entry("accepts expirations up to the maximum", `,"expirationSeconds":3600,"maxExpirationSeconds":3600`, ""),
+		Entry("rejects longer expirations", `,"expirationSeconds":7200,"maxExpirationSeconds":3600`, "expirationSeconds 7200 exceeds maxExpirationSeconds 3600"),
+		Entry("rejects longer defaulted expirations", `,"maxExpirationSeconds":600`, "expirationSeconds 3600 exceeds maxExpirationSeconds 600"),
+		Entry("rejects longer additional tokens", `,"expirationSeconds":600,"maxExpirationSeconds":600,"additionalTokens":[{"serviceAccountName":"ro","serviceAccountNamespace":"ns","tokenKey":"ro","expirationSeconds":900}]`, "additional token 0: expirationSeconds 900 exceeds maxExpirationSeconds 600"),
+		Entry("rejects negative values", `,"maxExpirationSeconds":-1`, "must not be negative"),
+		Entry("rejects negative token rates", `,"maxTokensPerMinute":-1`, "maxTokensPerMinute must not be negative"),
+	)
+
+	DescribeTable("validates apiVersion and kind",
+		func(header, expectedErr string) {
+			config, err := controllers.LoadConfig(writeConfig("config.json", `{`+header+`"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"}]}`))
+			if expectedErr != "" {
+				Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.APIVersion).To(Equal(controllers.ConfigAPIVersion))
+			Expect(config.Kind).To(Equal(controllers.ConfigKind))
+		},
+		Entry("defaults a missing version", "", ""),
+		Entry("accepts the current version", `"apiVersion":"metal-token-rotate.ironcore.dev/v1","kind":"Config",`, ""),
+		Entry("rejects unknown versions", `"apiVersion":"metal-token-rotate.ironcore.dev/v2",`, `unsupported config apiVersion "metal-token-rotate.ironcore.dev/v2"`),
+		Entry("rejects unknown kinds", `"kind":"ClusterList",`, `unsupported config kind "ClusterList"`),
+	)
+
+	DescribeTable("validates allowedServiceAccounts",
+		func(patterns, expectedErr string) {
+			_, err := controllers.LoadConfig(writeConfig("config.json", `{"allowedServiceAccounts":`+patterns+`,"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"}]}`))
+			if expectedErr != "" {
+				Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+		},
+		Entry("accepts names and patterns", `["ns/sa","metal-*/token-*"]`, ""),
+		Entry("rejects entries without namespace", `["sa"]`, `invalid allowedServiceAccounts entry "sa", expected namespace/name`),
+		Entry("rejects empty names", `["ns/"]`, `invalid allowedServiceAccounts entry "ns/"`),
+		Entry("rejects too many slashes", `["ns/sa/x"]`, `invalid allowedServiceAccounts entry "ns/sa/x"`),
+		Entry("rejects malformed patterns", `["ns/[sa"]`, "syntax error in pattern"),
+	)
+
+	It("rejects invalid service account namespace templates", func() {
+		_, err := controllers.LoadConfig(writeConfig("config.json", `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"{{ .TargetNamespace","identity":"cluster-a"}]}`))
+		Expect(err).To(MatchError(ContainSubstring("invalid serviceAccountNamespace")))
+	})
+
+	It("rejects impersonated groups without a user", func() {
+		_, err := controllers.LoadConfig(writeConfig("config.json", `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a","impersonateGroups":["garden"]}]}`))
+		Expect(err).To(MatchError(ContainSubstring("impersonateGroups requires impersonateUser")))
+	})
+
+	It("rejects a kubeconfig context without target secret", func() {
+		_, err := controllers.LoadConfig(writeConfig("config.json", `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a","targetKubeconfigContext":"metal"}]}`))
+		Expect(err).To(MatchError(ContainSubstring("targetKubeconfigContext requires targetSecretName and targetSecretNamespace")))
+	})
+
+	It("rejects empty audiences", func() {
+		_, err := controllers.LoadConfig(writeConfig("config.json", `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a","audiences":["metal",""]}]}`))
+		Expect(err).To(MatchError(ContainSubstring("audiences must not contain empty entries")))
+	})
+
+	It("rejects token sinks that the reconciler does not have", func() {
+		config, err := controllers.LoadConfig(writeConfig("config.json", `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a","tokenSinks":["vault"]},{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-b","tokenSinks":["vault","s3"]}]}`))
+		Expect(err).ToNot(HaveOccurred())
+		sinks := map[string]controllers.TokenSink{"vault": controllers.SecretTokenSink{}}
+		Expect(config.CheckTokenSinks(sinks)).To(MatchError(`invalid cluster at index 1: unknown token sink "s3"`))
+		sinks["s3"] = controllers.SecretTokenSink{}
+		Expect(config.CheckTokenSinks(sinks)).To(Succeed())
+	})
+
+	It("keeps custom secret keys", func() {
+		config, err := controllers.LoadConfig(writeConfig("config.json", `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a","secretKeys":{"tokenKey":"bearerToken","namespaceKey":"targetNamespace"}}]}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.Clusters[0].SecretKeys).To(Equal(controllers.SecretKeys{
+			TokenKey:     "bearerToken",
+			UsernameKey:  "username",
+			NamespaceKey: "targetNamespace",
+		}))
+	})
+
+	It("defaults the expiration of additional tokens", func() {
+		config, err := controllers.LoadConfig(writeConfig("config.json", `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a","expirationSeconds":900,"additionalTokens":[{"serviceAccountName":"ro","serviceAccountNamespace":"ns","tokenKey":"readOnlyToken"}]}]}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.Clusters[0].AdditionalTokens).To(ConsistOf(controllers.TokenConfig{
+			ServiceAccountName:      "ro",
+			ServiceAccountNamespace: "ns",
+			TokenKey:                "readOnlyToken",
+			ExpirationSeconds:       900,
+		}))
+	})
+
+	It("rejects additional tokens reusing a key", func() {
+		_, err := controllers.LoadConfig(writeConfig("config.json", `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a","additionalTokens":[{"serviceAccountName":"ro","serviceAccountNamespace":"ns","tokenKey":"token"}]}]}`))
+		Expect(err).To(MatchError(ContainSubstring(`key "token" is already used`)))
+	})
+
+	It("rejects duplicate identities", func() {
+		_, err := controllers.LoadConfig(writeConfig("config.json", `{"items":[
+			{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"},
+			{"serviceAccountName":"other","serviceAccountNamespace":"ns","identity":"cluster-a"}
+		]}`))
+		Expect(err).To(MatchError(`invalid cluster at index 1: identity "cluster-a" is already used by cluster at index 0`))
+	})
+
+	It("warns about clusters sharing a service account", func() {
+		config, err := controllers.LoadConfig(writeConfig("config.json", `{"items":[
+			{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"},
+			{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-b"}
+		]}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.Warnings()).To(ConsistOf("clusters at index 0 and 1 use the same service account ns/sa"))
+	})
+
+	It("warns about clusters whose service account is not allowed", func() {
+		config, err := controllers.LoadConfig(writeConfig("config.json", `{"allowedServiceAccounts":["ns/*"],"items":[
+			{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"},
+			{"serviceAccountName":"sa","serviceAccountNamespace":"kube-system","identity":"cluster-b"}
+		]}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.Warnings()).To(ConsistOf("cluster at index 1 uses service account kube-system/sa, which is not in allowedServiceAccounts"))
+	})
+
+	Describe("from a directory", func() {
+
+		var dir string
+
+		BeforeEach(func() {
+			dir = GinkgoT().TempDir()
+		})
+
+		writeFragment := func(name, content string) {
+			Expect(os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)).To(Succeed())
+		}
+
+		It("merges all config files sorted by name", func() {
+			writeFragment("b.yaml", `
+items:
+- serviceAccountName: sa
+  serviceAccountNamespace: ns
+  identity: cluster-b
+`)
+			writeFragment("a.json", `{"defaultExpirationSeconds":600,"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"}]}`)
+			writeFragment("README.md", "not a config")
+			writeFragment(".hidden.json", "not a config either")
+
+			config, err := controllers.LoadConfig(dir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Clusters).To(HaveLen(2))
+			Expect(config.Clusters[0].Identity).To(Equal("cluster-a"))
+			Expect(config.Clusters[1].Identity).To(Equal("cluster-b"))
+			Expect(config.Clusters[1].ExpirationSeconds).To(Equal(int64(600)))
+		})
+
+		It("rejects duplicate identities across files", func() {
+			writeFragment("a.json", `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"}]}`)
+			writeFragment("b.json", `{"items":[{"serviceAccountName":"other","serviceAccountNamespace":"ns","identity":"cluster-a"}]}`)
+			_, err := controllers.LoadConfig(dir)
+			Expect(err).To(MatchError(ContainSubstring(`identity "cluster-a" is already used`)))
+		})
+
+		It("names the file with an invalid fragment", func() {
+			writeFragment("a.json", `{"apiVersion":"v0","items":[]}`)
+			_, err := controllers.LoadConfig(dir)
+			Expect(err).To(MatchError(ContainSubstring("a.json: unsupported config apiVersion")))
+		})
+
+		It("rejects conflicting global defaults", func() {
+			writeFragment("a.json", `{"defaultExpirationSeconds":600,"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"}]}`)
+			writeFragment("b.json", `{"defaultExpirationSeconds":900,"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-b"}]}`)
+			_, err := controllers.LoadConfig(dir)
+			Expect(err).To(MatchError(ContainSubstring("b.json: defaultExpirationSeconds 900 conflicts with 600")))
+		})
+
+		It("rejects a directory without clusters", func() {
+			_, err := controllers.LoadConfig(dir)
+			Expect(err).To(MatchError("no clusters found in config"))
+		})
+
+	})
+
+	It("looks up clusters by identity", func() {
+		config, err := controllers.LoadConfig(writeConfig("config.json", `{"items":[
+			{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"},
+			{"serviceAccountName":"other","serviceAccountNamespace":"ns","identity":"cluster-b"}
+		]}`))
+		Expect(err).ToNot(HaveOccurred())
+		cluster, ok := config.Cluster("cluster-b")
+		Expect(ok).To(BeTrue())
+		Expect(cluster.ServiceAccountName).To(Equal("other"))
+		_, ok = config.Cluster("cluster-c")
+		Expect(ok).To(BeFalse())
+	})
+
+})
+
+var _ = Describe("ParseConfigData", func() {
+
+	It("merges the config keys sorted by name", func() {
+		config, err := controllers.ParseConfigData(map[string]string{
+			"b.yaml":    "items:\n- serviceAccountName: sa\n  serviceAccountNamespace: ns\n  identity: cluster-b\n",
+			"a.json":    `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"}]}`,
+			"README.md": "not a config",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.Clusters).To(HaveLen(2))
+		Expect(config.Clusters[0].Identity).To(Equal("cluster-a"))
+		Expect(config.Clusters[1].Identity).To(Equal("cluster-b"))
+	})
+
+	It("names the key with an invalid config", func() {
+		_, err := controllers.ParseConfigData(map[string]string{"config.json": `{"items":`})
+		Expect(err).To(MatchError(ContainSubstring("config.json: failed to unmarshal config")))
+	})
+
+})
+
+var _ = Describe("ResolveServiceAccountNamespaces", func() {
+
+	It("renders templated namespaces", func() {
+		cluster := controllers.ClusterConfig{
+			ServiceAccountNamespace: "{{ .TargetNamespace }}",
+			AdditionalTokens: []controllers.TokenConfig{
+				{ServiceAccountNamespace: "static"},
+				{ServiceAccountNamespace: "{{ .TargetNamespace }}-ro"},
+			},
+		}
+		resolved, namespaces, err := controllers.ResolveServiceAccountNamespaces(cluster, "metal")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(namespaces).To(Equal([]string{"metal", "metal-ro"}))
+		Expect(resolved.ServiceAccountNamespace).To(Equal("metal"))
+		Expect(resolved.AdditionalTokens[0].ServiceAccountNamespace).To(Equal("static"))
+		Expect(resolved.AdditionalTokens[1].ServiceAccountNamespace).To(Equal("metal-ro"))
+		Expect(cluster.AdditionalTokens[1].ServiceAccountNamespace).To(Equal("{{ .TargetNamespace }}-ro"))
+	})
+
+	It("rejects rendered namespaces that are invalid", func() {
+		_, _, err := controllers.ResolveServiceAccountNamespaces(controllers.ClusterConfig{ServiceAccountNamespace: "{{ .TargetNamespace }}"}, "")
+		Expect(err).To(MatchError(ContainSubstring("invalid serviceAccountNamespace")))
+	})
+
+})
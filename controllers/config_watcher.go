@@ -0,0 +1,157 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ConfigWatcher keeps the last valid config in memory and reloads it when the
+// config file or ConfigMap changes. An invalid config is logged and ignored,
+// so that a broken edit does not replace a working config.
+type ConfigWatcher struct {
+	Path string
+	// ConfigMap is read with Client instead of Path if Client is set.
+	ConfigMap types.NamespacedName
+	Client    client.WithWatch
+	Log       logr.Logger
+
+	config atomic.Pointer[Config]
+	// resourceVersion of the last ConfigMap that was loaded
+	configMapVersion string
+
+	mu        sync.Mutex
+	listeners []chan<- struct{}
+}
+
+// NewConfigWatcher loads the initial config from path. It fails if the
+// initial config is invalid, since there is no last-good config to fall back to.
+func NewConfigWatcher(path string, log logr.Logger) (*ConfigWatcher, error) {
+	w := &ConfigWatcher{Path: path, Log: log}
+	if err := w.reload(context.Background()); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Config returns the currently active config.
+func (w *ConfigWatcher) Config() *Config {
+	return w.config.Load()
+}
+
+// Notify makes the watcher send to ch after it reloaded the config. Sends do
+// not block, so a buffered channel coalesces reloads the receiver has not
+// handled yet. Notify must be called before Start.
+func (w *ConfigWatcher) Notify(ch chan<- struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.listeners = append(w.listeners, ch)
+}
+
+// Start watches the config directory or the directory containing the config
+// file until ctx is cancelled. A file's directory is watched instead of the
+// file itself because ConfigMap volumes replace files by swapping symlinks.
+func (w *ConfigWatcher) Start(ctx context.Context) error {
+	if w.Client != nil {
+		return w.watchConfigMap(ctx)
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create config watcher: %w", err)
+	}
+	defer watcher.Close()
+	if err := watcher.Add(w.watchedDir()); err != nil {
+		return fmt.Errorf("failed to watch config directory: %w", err)
+	}
+	// catch changes made between NewConfigWatcher and the watch being set up
+	w.reloadAndNotify(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Chmod) {
+				continue
+			}
+			w.reloadAndNotify(ctx)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			w.Log.Error(err, "config watcher error")
+		}
+	}
+}
+
+// watchedDir is the config directory itself or the directory containing the config file.
+func (w *ConfigWatcher) watchedDir() string {
+	if info, err := os.Stat(w.Path); err == nil && info.IsDir() {
+		return w.Path
+	}
+	return filepath.Dir(w.Path)
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. Every replica
+// needs an up-to-date config, not just the leader.
+func (w *ConfigWatcher) NeedLeaderElection() bool {
+	return false
+}
+
+func (w *ConfigWatcher) reloadAndNotify(ctx context.Context) {
+	if err := w.reload(ctx); err != nil {
+		w.Log.Error(err, "keeping last valid config", w.source()...)
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, ch := range w.listeners {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (w *ConfigWatcher) reload(ctx context.Context) error {
+	var config Config
+	var err error
+	if w.Client != nil {
+		config, err = w.loadConfigMap(ctx)
+	} else {
+		config, err = LoadConfig(w.Path)
+	}
+	if err != nil {
+		configReloadsTotal.WithLabelValues(resultError).Inc()
+		return err
+	}
+	configReloadsTotal.WithLabelValues(resultSuccess).Inc()
+	configClusters.Set(float64(len(config.Clusters)))
+	w.config.Store(&config)
+	w.Log.Info("loaded config", append(w.source(), "clusters", len(config.Clusters))...)
+	for _, warning := range config.Warnings() {
+		w.Log.Info("WARNING: "+warning, w.source()...)
+	}
+	return nil
+}
+
+// source returns the log values naming where the config is loaded from.
+func (w *ConfigWatcher) source() []any {
+	if w.Client != nil {
+		return []any{"configMap", w.ConfigMap.String()}
+	}
+	return []any{"path", w.Path}
+}
@@ -0,0 +1,111 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/ironcore-dev/metal-token-rotate/controllers"
+)
+
+var _ = Describe("ConfigWatcher", func() {
+
+	const (
+		validConfig   = `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"}]}`
+		updatedConfig = `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-b"}]}`
+	)
+
+	var (
+		path    string
+		watcher *controllers.ConfigWatcher
+	)
+
+	identities := func() []string {
+		var result []string
+		for _, c := range watcher.Config().Clusters {
+			result = append(result, c.Identity)
+		}
+		return result
+	}
+
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "config.json")
+		Expect(os.WriteFile(path, []byte(validConfig), 0644)).To(Succeed())
+		var err error
+		watcher, err = controllers.NewConfigWatcher(path, GinkgoLogr)
+		Expect(err).ToNot(HaveOccurred())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- watcher.Start(ctx)
+		}()
+		DeferCleanup(func() {
+			cancel()
+			Expect(<-done).To(Succeed())
+		})
+	})
+
+	It("refuses to start with an invalid initial config", func() {
+		Expect(os.WriteFile(path, []byte(`{"items":[]}`), 0644)).To(Succeed())
+		_, err := controllers.NewConfigWatcher(path, GinkgoLogr)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("picks up a valid config change", func() {
+		Expect(identities()).To(ConsistOf("cluster-a"))
+		Expect(os.WriteFile(path, []byte(updatedConfig), 0644)).To(Succeed())
+		Eventually(identities).Should(ConsistOf("cluster-b"))
+	})
+
+	It("keeps the last valid config when the new one is invalid", func() {
+		failuresBefore := testutil.ToFloat64(controllers.ConfigReloadsTotal.WithLabelValues("error"))
+		Expect(os.WriteFile(path, []byte(`{"items":[{"identity":"broken"}]}`), 0644)).To(Succeed())
+		Consistently(identities).Should(ConsistOf("cluster-a"))
+		Expect(testutil.ToFloat64(controllers.ConfigReloadsTotal.WithLabelValues("error"))).To(BeNumerically(">", failuresBefore))
+		Expect(testutil.ToFloat64(controllers.ConfigClusters)).To(Equal(1.0))
+	})
+
+	It("picks up new files in a config directory", func(ctx SpecContext) {
+		dir := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(dir, "a.json"), []byte(validConfig), 0644)).To(Succeed())
+		dirWatcher, err := controllers.NewConfigWatcher(dir, GinkgoLogr)
+		Expect(err).ToNot(HaveOccurred())
+		go func() {
+			defer GinkgoRecover()
+			Expect(dirWatcher.Start(ctx)).To(Succeed())
+		}()
+
+		Expect(os.WriteFile(filepath.Join(dir, "b.json"), []byte(updatedConfig), 0644)).To(Succeed())
+		Eventually(func() int {
+			return len(dirWatcher.Config().Clusters)
+		}).Should(Equal(2))
+	})
+
+	It("notifies listeners after a reload", func(ctx SpecContext) {
+		notifyPath := filepath.Join(GinkgoT().TempDir(), "config.json")
+		Expect(os.WriteFile(notifyPath, []byte(validConfig), 0644)).To(Succeed())
+		notifyWatcher, err := controllers.NewConfigWatcher(notifyPath, GinkgoLogr)
+		Expect(err).ToNot(HaveOccurred())
+		changes := make(chan struct{}, 1)
+		notifyWatcher.Notify(changes)
+		go func() {
+			defer GinkgoRecover()
+			Expect(notifyWatcher.Start(ctx)).To(Succeed())
+		}()
+		// the reload right after the watch is set up
+		Eventually(changes).Should(Receive())
+
+		Expect(os.WriteFile(notifyPath, []byte(updatedConfig), 0644)).To(Succeed())
+		Eventually(changes).Should(Receive())
+		Expect(notifyWatcher.Config().Clusters[0].Identity).To(Equal("cluster-b"))
+	})
+
+})
@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+)
+
+// fingerprintLength is the number of hex digits of a fingerprint, enough to
+// tell tokens apart in logs.
+const fingerprintLength = 12
+
+// dataChange is a changed key of a managed secret. Values may be
+// credentials, so only their fingerprints are logged.
+type dataChange struct {
+	Key string `json:"key"`
+	// Old and New are empty if the key was added or removed.
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+}
+
+// fingerprint returns a prefix of the SHA-256 hash of value, or an empty
+// string for an empty value.
+func fingerprint(value []byte) string {
+	if len(value) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(value)
+	return hex.EncodeToString(sum[:])[:fingerprintLength]
+}
+
+// dataChanges returns the keys whose values differ between oldData and
+// newData, sorted by key.
+func dataChanges(oldData, newData map[string][]byte) []dataChange {
+	var changes []dataChange
+	for key, value := range newData {
+		if old, ok := oldData[key]; !ok || !bytes.Equal(old, value) {
+			changes = append(changes, dataChange{Key: key, Old: fingerprint(old), New: fingerprint(value)})
+		}
+	}
+	for key, old := range oldData {
+		if _, ok := newData[key]; !ok {
+			changes = append(changes, dataChange{Key: key, Old: fingerprint(old)})
+		}
+	}
+	slices.SortFunc(changes, func(a, b dataChange) int {
+		return cmp.Compare(a.Key, b.Key)
+	})
+	return changes
+}
+
+// changesKey reports whether any of keys is among changes.
+func changesKey(changes []dataChange, keys ...string) bool {
+	return slices.ContainsFunc(changes, func(change dataChange) bool {
+		return slices.Contains(keys, change.Key)
+	})
+}
@@ -0,0 +1,39 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/ironcore-dev/metal-token-rotate/controllers"
+)
+
+var _ = Describe("dataChanges", func() {
+
+	It("fingerprints values instead of returning them", func() {
+		fingerprint := controllers.Fingerprint([]byte("secret-token"))
+		Expect(fingerprint).To(HaveLen(12))
+		Expect(fingerprint).ToNot(ContainSubstring("secret"))
+		Expect(controllers.Fingerprint([]byte("secret-token"))).To(Equal(fingerprint))
+		Expect(controllers.Fingerprint([]byte("other-token"))).ToNot(Equal(fingerprint))
+		Expect(controllers.Fingerprint(nil)).To(BeEmpty())
+	})
+
+	It("returns added, changed and removed keys sorted by key", func() {
+		oldData := map[string][]byte{"token": []byte("old"), "username": []byte("sa"), "kubeconfig": []byte("config")}
+		newData := map[string][]byte{"token": []byte("new"), "username": []byte("sa"), "namespace": []byte("ns")}
+		Expect(controllers.DataChanges(oldData, newData)).To(Equal([]controllers.DataChange{
+			{Key: "kubeconfig", Old: controllers.Fingerprint([]byte("config"))},
+			{Key: "namespace", New: controllers.Fingerprint([]byte("ns"))},
+			{Key: "token", Old: controllers.Fingerprint([]byte("old")), New: controllers.Fingerprint([]byte("new"))},
+		}))
+	})
+
+	It("returns nothing for unchanged data", func() {
+		data := map[string][]byte{"token": []byte("token")}
+		Expect(controllers.DataChanges(data, data)).To(BeEmpty())
+	})
+
+})
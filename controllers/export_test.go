@@ -0,0 +1,186 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+func RequeueAfter(token string, thresholdPercent int64, clockSkew time.Duration) time.Duration {
+	return requeueAfter(token, thresholdPercent, 0, clockSkew, DefaultRequeueAfter, maxRequeueAfter)
+}
+
+func RequeueAfterMinInterval(token string, thresholdPercent int64, minInterval time.Duration) time.Duration {
+	return requeueAfter(token, thresholdPercent, minInterval, 0, DefaultRequeueAfter, maxRequeueAfter)
+}
+
+var MakeTargetClient = makeTargetClient
+
+var LifetimeDiverges = lifetimeDiverges
+
+type DataChange = dataChange
+
+var (
+	DataChanges = dataChanges
+	Fingerprint = fingerprint
+)
+
+var (
+	TokenRotationsTotal            = tokenRotationsTotal
+	SecretsSkippedTotal            = secretsSkippedTotal
+	LastReconcileTimestamp         = lastReconcileTimestamp
+	IdentityLastReconcileTimestamp = identityLastReconcileTimestamp
+	ReconcileQueueDepth            = reconcileQueueDepth
+	ConfigReloadsTotal             = configReloadsTotal
+	ConfigClusters                 = configClusters
+)
+
+var (
+	NewQueue        = newQueue
+	ReconcileResult = reconcileResult
+)
+
+// ParseAutoprovisionValue returns the namespaces of value joined by commas.
+func ParseAutoprovisionValue(value string) (identity, namespaces string, err error) {
+	t, err := parseAutoprovisionValue(value)
+	return t.identity, strings.Join(t.namespaces, ","), err
+}
+
+// ResolveTargetNamespace parses value and resolves its namespaces for secret,
+// which are joined by commas.
+func ResolveTargetNamespace(value string, secret *corev1.Secret) (string, error) {
+	t, err := parseAutoprovisionValue(value)
+	if err != nil {
+		return "", err
+	}
+	namespaces, err := t.resolveNamespaces(secret)
+	return strings.Join(namespaces, ","), err
+}
+
+func (r *SecretReconciler) Jitter(d time.Duration) time.Duration {
+	return r.jitter(d)
+}
+
+func (r *SecretReconciler) NeedsToken(ctx context.Context, metalClient client.Client, token string) (bool, error) {
+	return r.needsToken(ctx, ensureTokenParams{
+		metalClient:             metalClient,
+		log:                     r.Log,
+		renewalThresholdPercent: 50,
+		currentToken:            token,
+	})
+}
+
+func (r *SecretReconciler) NeedsTokenForAudiences(ctx context.Context, metalClient client.Client, token string, audiences []string) (bool, error) {
+	return r.needsToken(ctx, ensureTokenParams{
+		metalClient:             metalClient,
+		log:                     r.Log,
+		renewalThresholdPercent: 50,
+		currentToken:            token,
+		audiences:               audiences,
+	})
+}
+
+func (r *SecretReconciler) NeedsTokenRenewAfter(ctx context.Context, metalClient client.Client, token string, renewAfter time.Time) (bool, error) {
+	return r.needsToken(ctx, ensureTokenParams{
+		metalClient:             metalClient,
+		log:                     r.Log,
+		renewalThresholdPercent: 50,
+		currentToken:            token,
+		renewAfter:              renewAfter,
+	})
+}
+
+func (r *SecretReconciler) NeedsTokenMaxAge(ctx context.Context, metalClient client.Client, token string, maxTokenAge time.Duration, renewAfter time.Time) (bool, error) {
+	return r.needsToken(ctx, ensureTokenParams{
+		metalClient:             metalClient,
+		log:                     r.Log,
+		renewalThresholdPercent: 50,
+		currentToken:            token,
+		maxTokenAge:             maxTokenAge,
+		renewAfter:              renewAfter,
+	})
+}
+
+func (r *SecretReconciler) NeedsTokenWithoutReview(ctx context.Context, metalClient client.Client, token string) (bool, error) {
+	return r.needsToken(ctx, ensureTokenParams{
+		metalClient:             metalClient,
+		log:                     r.Log,
+		renewalThresholdPercent: 50,
+		currentToken:            token,
+		skipTokenReview:         true,
+	})
+}
+
+// EnsureToken ensures a token for the service account ns/sa of secret.
+func (r *SecretReconciler) EnsureToken(ctx context.Context, metalClient client.Client, secret *corev1.Secret, token string, force bool) (string, error) {
+	return r.ensureToken(ctx, ensureTokenParams{
+		metalClient:             metalClient,
+		log:                     r.Log,
+		secret:                  secret,
+		key:                     "token",
+		identity:                "test",
+		serviceAccount:          types.NamespacedName{Namespace: "ns", Name: "sa"},
+		expirationSeconds:       600,
+		renewalThresholdPercent: 50,
+		currentToken:            token,
+		force:                   force,
+	})
+}
+
+func (r *SecretReconciler) TargetClient(ctx context.Context, targetSecret types.NamespacedName) (client.Client, error) {
+	targetClient, _, err := r.targetClients.get(ctx, r.LocalClient, targetSecret, "")
+	return targetClient, err
+}
+
+// NewTokenExpiryCollector returns a collector with a single secret
+// expiring at expiresAt and a function deleting that secret.
+func NewTokenExpiryCollector(secret types.NamespacedName, identity string, expiresAt time.Time, thresholdPercent int64) (prometheus.Collector, func()) {
+	c := newTokenExpiryCollector()
+	c.set(secret, tokenExpiry{identity: identity, expiresAt: expiresAt, renewalThresholdPercent: thresholdPercent})
+	return c, func() { c.delete(secret) }
+}
+
+// TokenExpiry is a secret for NewTokenExpiryCollectorFor.
+type TokenExpiry struct {
+	Secret   types.NamespacedName
+	Identity string
+	RenewAt  time.Time
+}
+
+// NewTokenExpiryCollectorFor returns a collector with the given secrets,
+// which expire in an hour.
+func NewTokenExpiryCollectorFor(expiries ...TokenExpiry) prometheus.Collector {
+	c := newTokenExpiryCollector()
+	for _, expiry := range expiries {
+		c.set(expiry.Secret, tokenExpiry{identity: expiry.Identity, expiresAt: Now().Add(time.Hour), renewAt: expiry.RenewAt})
+	}
+	return c
+}
+
+func (c *ClusterConfig) RenewalThresholdPercentFor(uid types.UID) int64 {
+	return c.renewalThresholdPercentFor(uid)
+}
+
+func ResolveServiceAccountNamespaces(c ClusterConfig, targetNamespace string) (ClusterConfig, []string, error) {
+	namespaces, err := c.resolveServiceAccountNamespaces(templateData{TargetNamespace: targetNamespace})
+	return c, namespaces, err
+}
+
+func (r *SecretReconciler) ImpersonatingClient(ctx context.Context, cluster *ClusterConfig, metalClient client.Client, config *rest.Config) (client.Client, error) {
+	return r.impersonatingClients.get(ctx, cluster, metalClient, config)
+}
+
+// AddConfigResync adds the runnable resyncing secrets on config changes to mgr.
+func (r *SecretReconciler) AddConfigResync(mgr manager.Manager) error {
+	return mgr.Add(newConfigResync(r))
+}
@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// impersonatingClientCache caches metal clients impersonating the configured
+// user per identity. An entry is rebuilt when the underlying metal config or
+// the impersonated user changes.
+type impersonatingClientCache struct {
+	mu      sync.Mutex
+	entries map[string]impersonatingClientEntry
+}
+
+type impersonatingClientEntry struct {
+	config      *rest.Config
+	impersonate rest.ImpersonationConfig
+	client      client.Client
+}
+
+func (e impersonatingClientEntry) matches(config *rest.Config, impersonate rest.ImpersonationConfig) bool {
+	return e.config == config && e.impersonate.UserName == impersonate.UserName &&
+		slices.Equal(e.impersonate.Groups, impersonate.Groups)
+}
+
+// get returns a client for config impersonating the user and groups of
+// cluster. Before a client is built, metalClient is used to check that the
+// controller is allowed to impersonate them.
+func (c *impersonatingClientCache) get(ctx context.Context, cluster *ClusterConfig, metalClient client.Client, config *rest.Config) (client.Client, error) {
+	if config == nil {
+		return nil, errors.New("impersonation requires the rest config of the metal cluster")
+	}
+	impersonate := rest.ImpersonationConfig{UserName: cluster.ImpersonateUser, Groups: cluster.ImpersonateGroups}
+	c.mu.Lock()
+	entry, ok := c.entries[cluster.Identity]
+	c.mu.Unlock()
+	if ok && entry.matches(config, impersonate) {
+		return entry.client, nil
+	}
+
+	if err := canImpersonate(ctx, metalClient, "users", impersonate.UserName); err != nil {
+		return nil, err
+	}
+	for _, group := range impersonate.Groups {
+		if err := canImpersonate(ctx, metalClient, "groups", group); err != nil {
+			return nil, err
+		}
+	}
+	impersonatingConfig := rest.CopyConfig(config)
+	impersonatingConfig.Impersonate = impersonate
+	impersonatingClient, err := client.New(impersonatingConfig, client.Options{Scheme: metalClient.Scheme()})
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]impersonatingClientEntry)
+	}
+	c.entries[cluster.Identity] = impersonatingClientEntry{
+		config:      config,
+		impersonate: impersonate,
+		client:      impersonatingClient,
+	}
+	return impersonatingClient, nil
+}
+
+// canImpersonate checks with a SelfSubjectAccessReview that the metal client
+// may impersonate the named user or group.
+func canImpersonate(ctx context.Context, metalClient client.Client, resource, name string) error {
+	review := authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Verb:     "impersonate",
+				Resource: resource,
+				Name:     name,
+			},
+		},
+	}
+	if err := metalClient.Create(ctx, &review); err != nil {
+		return fmt.Errorf("failed to check impersonation permissions: %w", err)
+	}
+	if !review.Status.Allowed {
+		return fmt.Errorf("not allowed to impersonate %s %q", resource, name)
+	}
+	return nil
+}
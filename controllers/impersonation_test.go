@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers_test
+
+import (
+	"context"
+	"slices"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	"github.com/ironcore-dev/metal-token-rotate/controllers"
+)
+
+// accessReviewClient returns a fake metal client allowing the impersonation
+// of the given users and groups.
+func accessReviewClient(allowed ...string) (client.Client, *int) {
+	reviews := 0
+	c := fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			if review, ok := obj.(*authorizationv1.SelfSubjectAccessReview); ok {
+				reviews++
+				attributes := review.Spec.ResourceAttributes
+				review.Status.Allowed = attributes.Verb == "impersonate" && slices.Contains(allowed, attributes.Resource+"/"+attributes.Name)
+				return nil
+			}
+			return c.Create(ctx, obj, opts...)
+		},
+	}).Build()
+	return c, &reviews
+}
+
+var _ = Describe("the impersonating client", func() {
+
+	var (
+		r       *controllers.SecretReconciler
+		config  *rest.Config
+		cluster *controllers.ClusterConfig
+	)
+
+	BeforeEach(func() {
+		r = &controllers.SecretReconciler{Log: GinkgoLogr}
+		config = &rest.Config{Host: "https://metal.example.com"}
+		cluster = &controllers.ClusterConfig{
+			Identity:          "cluster-a",
+			ImpersonateUser:   "garden-controller",
+			ImpersonateGroups: []string{"garden"},
+		}
+	})
+
+	It("is built and cached if impersonation is allowed", func(ctx SpecContext) {
+		metalClient, reviews := accessReviewClient("users/garden-controller", "groups/garden")
+		first, err := r.ImpersonatingClient(ctx, cluster, metalClient, config)
+		Expect(err).ToNot(HaveOccurred())
+		second, err := r.ImpersonatingClient(ctx, cluster, metalClient, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(second).To(BeIdenticalTo(first))
+		Expect(*reviews).To(Equal(2))
+	})
+
+	It("is rebuilt when the impersonated user changes", func(ctx SpecContext) {
+		metalClient, _ := accessReviewClient("users/garden-controller", "users/other", "groups/garden")
+		first, err := r.ImpersonatingClient(ctx, cluster, metalClient, config)
+		Expect(err).ToNot(HaveOccurred())
+		cluster.ImpersonateUser = "other"
+		second, err := r.ImpersonatingClient(ctx, cluster, metalClient, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(second).ToNot(BeIdenticalTo(first))
+	})
+
+	It("is refused if a group may not be impersonated", func(ctx SpecContext) {
+		metalClient, _ := accessReviewClient("users/garden-controller")
+		_, err := r.ImpersonatingClient(ctx, cluster, metalClient, config)
+		Expect(err).To(MatchError(`not allowed to impersonate groups "garden"`))
+	})
+
+})
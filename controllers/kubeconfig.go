@@ -0,0 +1,163 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const kubeconfigName = "metal"
+
+// caCertKey is the secret key the CA bundle is written to with EmitCACert,
+// matching the key of service account token secrets.
+const caCertKey = "ca.crt"
+
+// buildKubeconfig returns a kubeconfig authenticating with token against the
+// cluster described by config.
+func buildKubeconfig(config *rest.Config, namespace, token string) ([]byte, error) {
+	if config == nil {
+		return nil, errors.New("no rest config available for the metal cluster")
+	}
+	ca, err := caData(config)
+	if err != nil {
+		return nil, err
+	}
+	kubeconfig := clientcmdapi.Config{
+		Clusters: map[string]*clientcmdapi.Cluster{
+			kubeconfigName: {
+				Server:                   config.Host,
+				CertificateAuthorityData: ca,
+				InsecureSkipTLSVerify:    config.Insecure,
+			},
+		},
+		AuthInfos: map[string]*clientcmdapi.AuthInfo{
+			kubeconfigName: {Token: token},
+		},
+		Contexts: map[string]*clientcmdapi.Context{
+			kubeconfigName: {
+				Cluster:   kubeconfigName,
+				AuthInfo:  kubeconfigName,
+				Namespace: namespace,
+			},
+		},
+		CurrentContext: kubeconfigName,
+	}
+	return clientcmd.Write(kubeconfig)
+}
+
+// caData returns the CA bundle of config, reading it from CAFile if it is not inlined.
+func caData(config *rest.Config) ([]byte, error) {
+	if len(config.CAData) > 0 || config.CAFile == "" {
+		return config.CAData, nil
+	}
+	data, err := os.ReadFile(config.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+	return data, nil
+}
+
+// caCert returns the CA bundle of the metal cluster described by config.
+func caCert(config *rest.Config) ([]byte, error) {
+	if config == nil {
+		return nil, errors.New("no rest config available for the metal cluster")
+	}
+	ca, err := caData(config)
+	if err != nil {
+		return nil, err
+	}
+	if len(ca) == 0 {
+		return nil, errors.New("the rest config of the metal cluster has no CA data or CA file")
+	}
+	return ca, nil
+}
+
+// invalidKubeconfigError is returned for target secrets whose kubeconfig
+// cannot be used, which has to be fixed by whoever maintains the secret.
+type invalidKubeconfigError struct {
+	err error
+}
+
+func (e *invalidKubeconfigError) Error() string {
+	return "invalid kubeconfig in target secret: " + e.err.Error()
+}
+
+func (e *invalidKubeconfigError) Unwrap() error {
+	return e.err
+}
+
+// validateKubeconfig checks that the named context of a kubeconfig, or its
+// current context if contextName is empty, refers to a cluster with a server
+// and to a user with credentials, so that a broken kubeconfig is reported with
+// what is missing.
+func validateKubeconfig(kubeconfig *clientcmdapi.Config, contextName string) error {
+	if contextName == "" {
+		if kubeconfig.CurrentContext == "" {
+			return errors.New("current-context is not set")
+		}
+		if _, ok := kubeconfig.Contexts[kubeconfig.CurrentContext]; !ok {
+			return fmt.Errorf("current-context %q is not defined in contexts", kubeconfig.CurrentContext)
+		}
+		contextName = kubeconfig.CurrentContext
+	}
+	kubecontext, ok := kubeconfig.Contexts[contextName]
+	if !ok {
+		return fmt.Errorf("context %q is not defined in contexts", contextName)
+	}
+	cluster, ok := kubeconfig.Clusters[kubecontext.Cluster]
+	if !ok {
+		return fmt.Errorf("context %q refers to cluster %q, which is not defined in clusters", contextName, kubecontext.Cluster)
+	}
+	if cluster.Server == "" {
+		return fmt.Errorf("cluster %q has no server", kubecontext.Cluster)
+	}
+	user, ok := kubeconfig.AuthInfos[kubecontext.AuthInfo]
+	if !ok {
+		return fmt.Errorf("context %q refers to user %q, which is not defined in users", contextName, kubecontext.AuthInfo)
+	}
+	hasClientCertificate := (len(user.ClientCertificateData) > 0 || user.ClientCertificate != "") &&
+		(len(user.ClientKeyData) > 0 || user.ClientKey != "")
+	if user.Token == "" && user.TokenFile == "" && !hasClientCertificate && user.Username == "" && user.Exec == nil && user.AuthProvider == nil {
+		return fmt.Errorf("user %q has no credentials, expected a token, a client certificate and key, basic auth or an exec plugin", kubecontext.AuthInfo)
+	}
+	return nil
+}
+
+// inlineCertificateAuthority replaces a CA file referenced by the cluster of
+// the named context, or of the current context if contextName is empty, with
+// the ca.crt key of the target secret. Kubeconfigs copied into secrets often
+// still refer to files on the machine they were copied from, which do not
+// exist in the controller. Files that do exist are kept. kubeconfig must have
+// been validated.
+func inlineCertificateAuthority(kubeconfig *clientcmdapi.Config, contextName string, secretData map[string][]byte) error {
+	if contextName == "" {
+		contextName = kubeconfig.CurrentContext
+	}
+	clusterName := kubeconfig.Contexts[contextName].Cluster
+	cluster := kubeconfig.Clusters[clusterName]
+	if cluster.CertificateAuthority == "" {
+		return nil
+	}
+	if len(cluster.CertificateAuthorityData) == 0 {
+		ca, ok := secretData[caCertKey]
+		if !ok {
+			// e.g. a CA bundle mounted into the controller
+			if _, err := os.Stat(cluster.CertificateAuthority); err == nil {
+				return nil
+			}
+			return fmt.Errorf("cluster %q refers to the CA file %s, which does not exist in the controller, embed the CA as certificate-authority-data or add it to the %s key of the secret",
+				clusterName, cluster.CertificateAuthority, caCertKey)
+		}
+		cluster.CertificateAuthorityData = ca
+	}
+	// clientcmd rejects clusters with both
+	cluster.CertificateAuthority = ""
+	return nil
+}
@@ -0,0 +1,217 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	resultSuccess = "success"
+	resultError   = "error"
+	resultRequeue = "requeue"
+)
+
+var (
+	tokenRotationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "metal_token_rotations_total",
+			Help: "Number of token rotations by identity and result.",
+		},
+		[]string{"identity", "result"},
+	)
+	tokenReviewFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "metal_token_review_failures_total",
+			Help: "Number of failed TokenReview requests by identity.",
+		},
+		[]string{"identity"},
+	)
+	tokenCreationDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "metal_token_creation_duration_seconds",
+			Help:    "Latency of TokenRequest calls by identity.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"identity"},
+	)
+	secretsSkippedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "metal_token_skipped_total",
+			Help: "Number of reconciles that left an annotated secret alone by reason.",
+		},
+		[]string{"reason"},
+	)
+	lastReconcileTimestamp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "metal_token_last_reconcile_timestamp_seconds",
+			Help: "Unix time of the last successful reconcile.",
+		},
+	)
+	identityLastReconcileTimestamp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "metal_token_identity_last_reconcile_timestamp_seconds",
+			Help: "Unix time of the last successful reconcile of a secret by identity.",
+		},
+		[]string{"identity"},
+	)
+	reconcileDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "metal_token_reconcile_duration_seconds",
+			Help:    "Duration of secret reconciles by result.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"result"},
+	)
+	configReloadsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "metal_config_reloads_total",
+			Help: "Number of config loads by result. Failed loads keep the last valid config.",
+		},
+		[]string{"result"},
+	)
+	configClusters = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "metal_config_clusters",
+			Help: "Number of clusters in the active config.",
+		},
+	)
+	reconcileQueueDepth = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "metal_token_reconcile_queue_depth",
+			Help: "Number of secrets waiting to be reconciled.",
+		},
+		func() float64 {
+			if queueLen := reconcileQueueLen.Load(); queueLen != nil {
+				return float64((*queueLen)())
+			}
+			return 0
+		},
+	)
+	tokenExpiries = newTokenExpiryCollector()
+)
+
+// reconcileQueueLen returns the length of the work queue of the controller
+// once it was started.
+var reconcileQueueLen atomic.Pointer[func() int]
+
+func init() {
+	metrics.Registry.MustRegister(
+		tokenRotationsTotal,
+		tokenReviewFailuresTotal,
+		tokenCreationDuration,
+		secretsSkippedTotal,
+		lastReconcileTimestamp,
+		identityLastReconcileTimestamp,
+		reconcileDuration,
+		reconcileQueueDepth,
+		configReloadsTotal,
+		configClusters,
+		tokenExpiries,
+	)
+}
+
+// recordSuccessfulReconcile updates the last reconcile timestamps. identity
+// is empty for secrets that did not get as far as matching a cluster config.
+func recordSuccessfulReconcile(identity string) {
+	now := float64(Now().Unix())
+	lastReconcileTimestamp.Set(now)
+	if identity != "" {
+		identityLastReconcileTimestamp.WithLabelValues(identity).Set(now)
+	}
+}
+
+// tokenExpiry is the metric state of a managed secret.
+type tokenExpiry struct {
+	identity string
+	// expiresAt is the expiry of the token in the secret that expires first
+	expiresAt               time.Time
+	renewalThresholdPercent int64
+	// renewAt is when the first token in the secret is due for rotation
+	renewAt time.Time
+}
+
+// tokenExpiryCollector computes the time until expiry when scraped, so that
+// the value is current even if the secret was last reconciled long ago. For
+// the same reason, it counts the secrets whose rotation is overdue when scraped.
+type tokenExpiryCollector struct {
+	untilExpiry      *prometheus.Desc
+	renewalThreshold *prometheus.Desc
+	overThreshold    *prometheus.Desc
+
+	mu      sync.Mutex
+	secrets map[types.NamespacedName]tokenExpiry
+}
+
+func newTokenExpiryCollector() *tokenExpiryCollector {
+	labels := []string{"namespace", "name", "identity"}
+	return &tokenExpiryCollector{
+		untilExpiry: prometheus.NewDesc("metal_token_seconds_until_expiry",
+			"Seconds until the first token in a managed secret expires.", labels, nil),
+		renewalThreshold: prometheus.NewDesc("metal_token_renewal_threshold_percent",
+			"Share of the token lifetime after which the tokens in a managed secret are rotated.", labels, nil),
+		overThreshold: prometheus.NewDesc("metal_token_secrets_over_renewal_threshold",
+			"Number of managed secrets with a token past its renewal threshold that was not rotated yet, by identity.", []string{"identity"}, nil),
+		secrets: make(map[types.NamespacedName]tokenExpiry),
+	}
+}
+
+func (c *tokenExpiryCollector) set(secret types.NamespacedName, expiry tokenExpiry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.secrets[secret] = expiry
+}
+
+// delete drops the series of a secret that is deleted or no longer managed.
+func (c *tokenExpiryCollector) delete(secret types.NamespacedName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.secrets, secret)
+}
+
+func (c *tokenExpiryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.untilExpiry
+	ch <- c.renewalThreshold
+	ch <- c.overThreshold
+}
+
+func (c *tokenExpiryCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := Now()
+	// identities without overdue secrets are reported with 0
+	overdue := make(map[string]int)
+	for secret, expiry := range c.secrets {
+		ch <- prometheus.MustNewConstMetric(c.untilExpiry, prometheus.GaugeValue,
+			expiry.expiresAt.Sub(now).Seconds(), secret.Namespace, secret.Name, expiry.identity)
+		ch <- prometheus.MustNewConstMetric(c.renewalThreshold, prometheus.GaugeValue,
+			float64(expiry.renewalThresholdPercent), secret.Namespace, secret.Name, expiry.identity)
+		overdue[expiry.identity] += 0
+		if !expiry.renewAt.IsZero() && now.After(expiry.renewAt) {
+			overdue[expiry.identity]++
+		}
+	}
+	for identity, count := range overdue {
+		ch <- prometheus.MustNewConstMetric(c.overThreshold, prometheus.GaugeValue, float64(count), identity)
+	}
+}
+
+// reconcileResult is the result label of reconcileDuration.
+func reconcileResult(result ctrl.Result, err error) string {
+	switch {
+	case err != nil:
+		return resultError
+	case result.RequeueAfter > 0:
+		return resultRequeue
+	default:
+		return resultSuccess
+	}
+}
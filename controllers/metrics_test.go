@@ -0,0 +1,89 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers_test
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/ironcore-dev/metal-token-rotate/controllers"
+)
+
+var _ = Describe("the token expiry metrics", func() {
+
+	var now time.Time
+
+	BeforeEach(func() {
+		now = time.Unix(1700000000, 0)
+		controllers.Now = func() time.Time { return now }
+		DeferCleanup(func() { controllers.Now = time.Now })
+	})
+
+	It("reports the time until expiry at scrape time", func() {
+		collector, _ := controllers.NewTokenExpiryCollector(types.NamespacedName{Namespace: "ns", Name: "secret"}, "cluster-a", now.Add(10*time.Minute), 50)
+		now = now.Add(4 * time.Minute)
+		Expect(testutil.CollectAndCompare(collector, strings.NewReader(`
+# HELP metal_token_secrets_over_renewal_threshold Number of managed secrets with a token past its renewal threshold that was not rotated yet, by identity.
+# TYPE metal_token_secrets_over_renewal_threshold gauge
+metal_token_secrets_over_renewal_threshold{identity="cluster-a"} 0
+# HELP metal_token_renewal_threshold_percent Share of the token lifetime after which the tokens in a managed secret are rotated.
+# TYPE metal_token_renewal_threshold_percent gauge
+metal_token_renewal_threshold_percent{identity="cluster-a",name="secret",namespace="ns"} 50
+# HELP metal_token_seconds_until_expiry Seconds until the first token in a managed secret expires.
+# TYPE metal_token_seconds_until_expiry gauge
+metal_token_seconds_until_expiry{identity="cluster-a",name="secret",namespace="ns"} 360
+`))).To(Succeed())
+	})
+
+	It("drops the series of deleted secrets", func() {
+		collector, deleteSecret := controllers.NewTokenExpiryCollector(types.NamespacedName{Namespace: "ns", Name: "secret"}, "cluster-a", now.Add(10*time.Minute), 50)
+		deleteSecret()
+		Expect(testutil.CollectAndCount(collector)).To(BeZero())
+	})
+
+	It("counts the secrets past their renewal threshold by identity", func() {
+		collector := controllers.NewTokenExpiryCollectorFor(
+			controllers.TokenExpiry{Secret: types.NamespacedName{Namespace: "ns", Name: "a"}, Identity: "cluster-a", RenewAt: now.Add(time.Minute)},
+			controllers.TokenExpiry{Secret: types.NamespacedName{Namespace: "ns", Name: "b"}, Identity: "cluster-a", RenewAt: now.Add(3 * time.Minute)},
+			controllers.TokenExpiry{Secret: types.NamespacedName{Namespace: "ns", Name: "c"}, Identity: "cluster-b", RenewAt: now.Add(3 * time.Minute)},
+		)
+		now = now.Add(2 * time.Minute)
+		Expect(testutil.CollectAndCompare(collector, strings.NewReader(`
+# HELP metal_token_secrets_over_renewal_threshold Number of managed secrets with a token past its renewal threshold that was not rotated yet, by identity.
+# TYPE metal_token_secrets_over_renewal_threshold gauge
+metal_token_secrets_over_renewal_threshold{identity="cluster-a"} 1
+metal_token_secrets_over_renewal_threshold{identity="cluster-b"} 0
+`), "metal_token_secrets_over_renewal_threshold")).To(Succeed())
+	})
+
+})
+
+var _ = Describe("the reconcile metrics", func() {
+
+	It("reports the depth of the reconcile queue", func() {
+		queue := controllers.NewQueue("metrics-test", workqueue.DefaultTypedControllerRateLimiter[reconcile.Request]())
+		DeferCleanup(queue.ShutDown)
+		queue.Add(reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "ns", Name: "secret"}})
+		Expect(testutil.ToFloat64(controllers.ReconcileQueueDepth)).To(Equal(1.0))
+	})
+
+	DescribeTable("labels reconciles by result",
+		func(result ctrl.Result, err error, expected string) {
+			Expect(controllers.ReconcileResult(result, err)).To(Equal(expected))
+		},
+		Entry("with errors", ctrl.Result{}, errors.New("failed"), "error"),
+		Entry("with requeues", ctrl.Result{RequeueAfter: time.Minute}, nil, "requeue"),
+		Entry("otherwise", ctrl.Result{}, nil, "success"),
+	)
+
+})
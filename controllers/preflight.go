@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Preflight checks for every cluster of the current config that its metal
+// cluster is reachable and that tokens may be requested for its service
+// account, without issuing any. It returns the failures of all clusters.
+func (r *SecretReconciler) Preflight(ctx context.Context) error {
+	var errs []error
+	for _, cluster := range r.ConfigWatcher.Config().Clusters {
+		if err := r.preflightCluster(ctx, &cluster); err != nil {
+			errs = append(errs, fmt.Errorf("cluster %s: %w", cluster.Identity, err))
+			continue
+		}
+		r.Log.Info("preflight check passed", "identity", cluster.Identity)
+	}
+	return errors.Join(errs...)
+}
+
+// preflightCluster asks the metal cluster of cluster with a
+// SelfSubjectAccessReview whether tokens may be requested. Templated service
+// account namespaces are only known per secret, so for them only the
+// connectivity is checked.
+func (r *SecretReconciler) preflightCluster(ctx context.Context, cluster *ClusterConfig) error {
+	metalClient := r.LocalClient
+	if cluster.TargetSecretName != "" && cluster.TargetSecretNamespace != "" {
+		targetCtx, cancel := r.withClientTimeout(ctx)
+		defer cancel()
+		var err error
+		metalClient, _, err = r.targetClients.get(targetCtx, r.LocalClient, types.NamespacedName{
+			Name:      cluster.TargetSecretName,
+			Namespace: cluster.TargetSecretNamespace,
+		}, cluster.TargetKubeconfigContext)
+		if err != nil {
+			return fmt.Errorf("unable to create metal cluster client: %w", err)
+		}
+	}
+	return r.canRequestTokens(ctx, metalClient, cluster)
+}
+
+func (r *SecretReconciler) canRequestTokens(ctx context.Context, metalClient client.Client, cluster *ClusterConfig) error {
+	namespace := cluster.ServiceAccountNamespace
+	if isTemplate(namespace) {
+		namespace = ""
+	}
+	review := authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace:   namespace,
+				Verb:        "create",
+				Resource:    "serviceaccounts",
+				Subresource: "token",
+				Name:        cluster.ServiceAccountName,
+			},
+		},
+	}
+	ctx, cancel := r.withClientTimeout(ctx)
+	defer cancel()
+	if err := metalClient.Create(ctx, &review); err != nil {
+		return fmt.Errorf("metal cluster is not reachable: %w", err)
+	}
+	if namespace != "" && !review.Status.Allowed {
+		return fmt.Errorf("not allowed to request tokens for service account %s/%s, grant create on serviceaccounts/token in namespace %s of the metal cluster",
+			namespace, cluster.ServiceAccountName, namespace)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	"github.com/ironcore-dev/metal-token-rotate/controllers"
+)
+
+var _ = Describe("the preflight check", func() {
+
+	// preflight runs the check against a metal client answering access
+	// reviews with allowed, or failing them with err.
+	preflight := func(ctx context.Context, config string, allowed bool, err error) ([]authorizationv1.ResourceAttributes, error) {
+		path := filepath.Join(GinkgoT().TempDir(), "config.json")
+		Expect(os.WriteFile(path, []byte(config), 0644)).To(Succeed())
+		configWatcher, loadErr := controllers.NewConfigWatcher(path, GinkgoLogr)
+		Expect(loadErr).ToNot(HaveOccurred())
+		var reviewed []authorizationv1.ResourceAttributes
+		metalClient := fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+			Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+				if review, ok := obj.(*authorizationv1.SelfSubjectAccessReview); ok {
+					reviewed = append(reviewed, *review.Spec.ResourceAttributes)
+					review.Status.Allowed = allowed
+					return err
+				}
+				return c.Create(ctx, obj, opts...)
+			},
+		}).Build()
+		r := &controllers.SecretReconciler{
+			LocalClient:   metalClient,
+			Log:           GinkgoLogr,
+			ConfigWatcher: configWatcher,
+		}
+		err = r.Preflight(ctx)
+		return reviewed, err
+	}
+
+	It("passes if tokens may be requested", func(ctx SpecContext) {
+		reviewed, err := preflight(ctx, `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"}]}`, true, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reviewed).To(ConsistOf(authorizationv1.ResourceAttributes{
+			Namespace:   "ns",
+			Verb:        "create",
+			Resource:    "serviceaccounts",
+			Subresource: "token",
+			Name:        "sa",
+		}))
+	})
+
+	It("fails if tokens may not be requested", func(ctx SpecContext) {
+		_, err := preflight(ctx, `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"}]}`, false, nil)
+		Expect(err).To(MatchError("cluster cluster-a: not allowed to request tokens for service account ns/sa, grant create on serviceaccounts/token in namespace ns of the metal cluster"))
+	})
+
+	It("fails for every unreachable cluster", func(ctx SpecContext) {
+		_, err := preflight(ctx, `{"items":[
+			{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"},
+			{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-b"}]}`, true, errors.New("connection refused"))
+		Expect(err).To(MatchError(ContainSubstring("cluster cluster-a: metal cluster is not reachable: connection refused")))
+		Expect(err).To(MatchError(ContainSubstring("cluster cluster-b: metal cluster is not reachable: connection refused")))
+	})
+
+	It("only checks the connectivity for templated namespaces", func(ctx SpecContext) {
+		reviewed, err := preflight(ctx, `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"{{ .TargetNamespace }}","identity":"cluster-a"}]}`, false, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reviewed).To(HaveLen(1))
+		Expect(reviewed[0].Namespace).To(BeEmpty())
+	})
+
+})
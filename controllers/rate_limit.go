@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimitedError is returned by ensureToken if the issuance limit of an
+// identity is exhausted.
+type rateLimitedError struct {
+	identity   string
+	retryAfter time.Duration
+}
+
+func (e *rateLimitedError) Error() string {
+	return fmt.Sprintf("token issuance for identity %s is rate limited, retrying in %s", e.identity, e.retryAfter)
+}
+
+// issuanceLimiters limit the token requests per identity, so that a consumer
+// clearing its token over and over cannot flood the metal cluster.
+type issuanceLimiters struct {
+	mu       sync.Mutex
+	limiters map[string]issuanceLimiter
+}
+
+type issuanceLimiter struct {
+	perMinute int64
+	limiter   *rate.Limiter
+}
+
+// reserve takes one token request of identity from the limit and returns
+// zero, or how long to wait if the limit is exhausted. perMinute <= 0 does
+// not limit.
+func (l *issuanceLimiters) reserve(identity string, perMinute int64) time.Duration {
+	if perMinute <= 0 {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry, ok := l.limiters[identity]
+	if !ok || entry.perMinute != perMinute {
+		if l.limiters == nil {
+			l.limiters = make(map[string]issuanceLimiter)
+		}
+		entry = issuanceLimiter{
+			perMinute: perMinute,
+			limiter:   rate.NewLimiter(rate.Limit(float64(perMinute)/60), int(perMinute)),
+		}
+		l.limiters[identity] = entry
+	}
+	now := Now()
+	reservation := entry.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return delay
+	}
+	return 0
+}
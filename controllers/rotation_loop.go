@@ -0,0 +1,80 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// a secret whose tokens were replaced before they were due
+	// rotationLoopLimit times within rotationLoopWindow is considered to be
+	// in a rotation loop, e.g. because its new tokens never pass the
+	// TokenReview, and is not rotated again for rotationLoopBackoff
+	rotationLoopLimit   = 5
+	rotationLoopWindow  = 10 * time.Minute
+	rotationLoopBackoff = 30 * time.Minute
+)
+
+// rotationLoopDetector remembers recent premature rotations per secret.
+type rotationLoopDetector struct {
+	mu        sync.Mutex
+	rotations map[types.NamespacedName][]time.Time
+}
+
+// record adds a rotation of secret at now.
+func (d *rotationLoopDetector) record(secret types.NamespacedName, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.rotations == nil {
+		d.rotations = make(map[types.NamespacedName][]time.Time)
+	}
+	d.rotations[secret] = append(d.recent(secret, now), now)
+}
+
+// looping reports whether secret was rotated rotationLoopLimit times within
+// rotationLoopWindow before now.
+func (d *rotationLoopDetector) looping(secret types.NamespacedName, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.recent(secret, now)) >= rotationLoopLimit
+}
+
+// forget drops the rotations of a secret that is no longer managed.
+func (d *rotationLoopDetector) forget(secret types.NamespacedName) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.rotations, secret)
+}
+
+// prematureRotation reports whether replacing currentToken counts towards
+// rotationLoopLimit, i.e. whether currentToken was not yet due for renewal.
+// Missing tokens do not count, tokens that cannot be parsed do.
+func (r *SecretReconciler) prematureRotation(currentToken string, thresholdPercent int64, maxTokenAge time.Duration) bool {
+	if currentToken == "" {
+		return false
+	}
+	claims, err := parseTokenClaims(currentToken)
+	if err != nil {
+		return true
+	}
+	dueAge := claims.renewalAge(thresholdPercent, r.MinRotationInterval)
+	if maxTokenAge > 0 && maxTokenAge < dueAge {
+		dueAge = maxTokenAge
+	}
+	return Now().Sub(claims.issuedAt())+r.ClockSkewTolerance < dueAge
+}
+
+// recent returns the rotations of secret within rotationLoopWindow before
+// now. It must be called with mu held.
+func (d *rotationLoopDetector) recent(secret types.NamespacedName, now time.Time) []time.Time {
+	rotations := d.rotations[secret]
+	for len(rotations) > 0 && now.Sub(rotations[0]) > rotationLoopWindow {
+		rotations = rotations[1:]
+	}
+	return rotations
+}
@@ -5,223 +5,1247 @@ package controllers
 
 import (
 	"context"
-	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
 	authenticationv1 "k8s.io/api/authentication/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
-// to be ovverriden in tests
+// to be overridden in tests
 var Now = time.Now
 
-const AutoprovisonAnnotationKey = "metal.ironcore.dev/autoprovision"
+// to be overridden in tests
+var RandFloat64 = rand.Float64 //nolint:gosec // jitter does not need a cryptographic random source
+
+const AutoprovisionAnnotationKey = "metal.ironcore.dev/autoprovision"
+
+// Deprecated: use AutoprovisionAnnotationKey.
+const AutoprovisonAnnotationKey = AutoprovisionAnnotationKey
+
+// AutoprovisionPausedAnnotationKey set to "true" makes the controller leave a
+// secret alone without removing its autoprovision annotation.
+const AutoprovisionPausedAnnotationKey = "metal.ironcore.dev/autoprovision-paused"
+
+const (
+	TokenIssuedAtAnnotationKey  = "metal.ironcore.dev/token-issued-at"
+	TokenExpiresAtAnnotationKey = "metal.ironcore.dev/token-expires-at"
+	// RotationGenerationAnnotationKey records the rotationGeneration of the
+	// cluster config the tokens of a secret were last force-rotated for.
+	RotationGenerationAnnotationKey = "metal.ironcore.dev/rotation-generation"
+	// RenewAfterAnnotationKey is set by operators to an RFC 3339 time before
+	// which the tokens of a secret are not rotated unless they expire.
+	RenewAfterAnnotationKey = "metal.ironcore.dev/renew-after"
+)
+
+// ManagedByLabelKey is set to ManagedByLabelValue on every secret the
+// controller has written tokens into.
+const (
+	ManagedByLabelKey   = "app.kubernetes.io/managed-by"
+	ManagedByLabelValue = "metal-token-rotate"
+)
+
+// TokenRevocationFinalizer is added to managed secrets, so that the controller
+// sees their deletion and can account for the tokens they contained.
+const TokenRevocationFinalizer = "metal.ironcore.dev/token-revocation"
+
+const (
+	EventReasonTokenIssued              = "TokenIssued"
+	EventReasonTokenRotated             = "TokenRotated"
+	EventReasonTokenReviewFailed        = "TokenReviewFailed"
+	EventReasonInvalidAnnotation        = "InvalidAnnotation"
+	EventReasonNoMatchingConfig         = "NoMatchingConfig"
+	EventReasonInvalidTargetKubeconfig  = "InvalidTargetKubeconfig"
+	EventReasonTargetNamespaceNotFound  = "TargetNamespaceNotFound"
+	EventReasonTokenLifetimeMismatch    = "TokenLifetimeMismatch"
+	EventReasonSecretTypeMismatch       = "SecretTypeMismatch"
+	EventReasonServiceAccountNotAllowed = "ServiceAccountNotAllowed"
+	EventReasonTokenIssuanceRateLimited = "TokenIssuanceRateLimited"
+	EventReasonRotationLoopDetected     = "RotationLoopDetected"
+	EventReasonAutoprovisionPaused      = "AutoprovisionPaused"
+	EventReasonServiceAccountNotFound   = "ServiceAccountNotFound"
+	EventReasonServiceAccountCreated    = "ServiceAccountCreated"
+	EventReasonInvalidRenewAfter        = "InvalidRenewAfter"
+)
 
 type SecretReconciler struct {
 	GardenClient client.Client
 	LocalClient  client.Client
-	Log          logr.Logger
-	ConfigPath   string
+	// LocalConfig is the rest.Config of LocalClient. It is used to build
+	// kubeconfigs for clusters without a target secret.
+	LocalConfig *rest.Config
+	// LocalCluster is optional. If set, target kubeconfig secrets are watched
+	// in it, so that clients are rebuilt as soon as a kubeconfig changes. This
+	// requires list and watch permissions on secrets in the local cluster.
+	LocalCluster  cluster.Cluster
+	Log           logr.Logger
+	ConfigWatcher *ConfigWatcher
+	// Recorder defaults to the manager's event recorder.
+	Recorder record.EventRecorder
+	// RequeueJitterPercent spreads requeues of secrets by adding up to this
+	// percentage of the requeue interval.
+	RequeueJitterPercent int64
+	// MaxConcurrentReconciles defaults to 1. State shared between reconciles
+	// is concurrency-safe and a given secret is never reconciled by two
+	// workers at once, so token requests for distinct secrets can run in parallel.
+	MaxConcurrentReconciles int
+	// ClockSkewTolerance makes tokens rotate this much earlier to account for
+	// clock differences between the controller and the API server.
+	ClockSkewTolerance time.Duration
+	// MinRotationInterval is the shortest token age at which tokens are
+	// rotated, unless they expire earlier, so that a low renewal threshold
+	// cannot make tokens rotate almost continuously. Not limited if unset.
+	MinRotationInterval time.Duration
+	// RetryBaseDelay and RetryMaxDelay bound the exponential backoff for
+	// failed reconciles. The controller-runtime default is used if unset.
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+	// DefaultRequeue is the requeue interval for tokens whose expiry cannot be
+	// determined. Defaults to DefaultRequeueAfter.
+	DefaultRequeue time.Duration
+	// ResyncOnConfigChange reconciles all annotated secrets after the config
+	// was reloaded, so that secrets skipped for lack of a matching cluster
+	// config are picked up once it is added.
+	ResyncOnConfigChange bool
+	// ClientTimeout bounds every call to the garden and metal clusters, so
+	// that a hanging API server cannot block a worker. Defaults to
+	// DefaultClientTimeout.
+	ClientTimeout time.Duration
+	// AnnotationKeys defaults to DefaultAnnotationKeys.
+	AnnotationKeys AnnotationKeys
+	// AuditSink is optional. If set, every issued token is recorded in it.
+	AuditSink AuditSink
+	// TokenSinks are additional stores for issued tokens by name. Clusters
+	// select them with tokenSinks. Tokens are always written to the secret.
+	TokenSinks map[string]TokenSink
+	// TokenRotationStatus maintains a TokenRotation next to each managed
+	// secret with the outcome of its last reconcile. This requires the
+	// TokenRotation CRD in the garden cluster.
+	TokenRotationStatus bool
+	// NewTokenClient returns the TokenClient for requesting and reviewing
+	// tokens with a metal cluster client. Defaults to NewTokenClient.
+	NewTokenClient func(metalClient client.Client) TokenClient
+
+	targetClients        targetClientCache
+	impersonatingClients impersonatingClientCache
+	issuanceLimiters     issuanceLimiters
+	rotationLoops        rotationLoopDetector
+	// identities for which TokenReview was forbidden and the degraded mode was logged
+	tokenReviewForbidden sync.Map
+	// identities for which the JWT-only mode of SkipTokenReview was logged
+	tokenReviewSkipped sync.Map
+}
+
+// reconcileReason says why a reconcile ended. It is logged at the end of every reconcile.
+type reconcileReason string
+
+const (
+	reasonNoAnnotation      reconcileReason = "NoAnnotation"
+	reasonInvalidAnnotation reconcileReason = "InvalidAnnotation"
+	reasonNoMatchingConfig  reconcileReason = "NoMatchingConfig"
+	// the secret keeps its current token until the pause annotation is removed
+	reasonPaused reconcileReason = "Paused"
+	// the secret keeps its current token until the namespace exists
+	reasonTargetNamespaceNotFound reconcileReason = "TargetNamespaceNotFound"
+	reasonSecretDeleted           reconcileReason = "SecretDeleted"
+	reasonTokenFresh              reconcileReason = "TokenFresh"
+	reasonTokenRotated            reconcileReason = "TokenRotated"
+	reasonTokenIssued             reconcileReason = "TokenIssued"
+	// the secret keeps its current token until the issuance limit allows a new one
+	reasonRateLimited reconcileReason = "RateLimited"
+	// the service account of a token is missing in the metal cluster
+	reasonServiceAccountNotFound reconcileReason = "ServiceAccountNotFound"
+	// the secret was modified while it was reconciled and is reconciled again
+	reasonConflict reconcileReason = "Conflict"
+	// the secret was rotated too often recently and is left alone for a while
+	reasonRotationLoop reconcileReason = "RotationLoop"
+	reasonError        reconcileReason = "Error"
+)
+
+// skipped reports whether an annotated secret was left alone, which usually
+// needs the attention of an operator.
+func (r reconcileReason) skipped() bool {
+	switch r {
+	case reasonInvalidAnnotation, reasonNoMatchingConfig, reasonPaused, reasonTargetNamespaceNotFound:
+		return true
+	default:
+		return false
+	}
+}
+
+// managed reports whether the secret still has tokens maintained by the controller.
+func (r reconcileReason) managed() bool {
+	switch r {
+	case reasonNoAnnotation, reasonInvalidAnnotation, reasonNoMatchingConfig, reasonSecretDeleted:
+		return false
+	default:
+		return true
+	}
 }
 
 func (r *SecretReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := r.Log.WithValues("name", req.Name, "namespace", req.Namespace)
-	config, err := LoadConfig(r.ConfigPath)
+	start := time.Now()
+	result, reason, err := r.reconcile(ctx, log, req, false)
+	reconcileDuration.WithLabelValues(reconcileResult(result, err)).Observe(time.Since(start).Seconds())
 	if err != nil {
-		log.Error(err, "unable to load config")
-		return ctrl.Result{}, err
+		reason = reasonError
+	}
+	if !reason.managed() {
+		tokenExpiries.delete(req.NamespacedName)
+		r.rotationLoops.forget(req.NamespacedName)
+	}
+	log.Info("reconcile finished", "reason", reason, "requeueAfter", result.RequeueAfter)
+	return result, err
+}
+
+// RotateNow replaces the tokens of a managed secret immediately, regardless of
+// their age. It fails if the secret is not managed.
+func (r *SecretReconciler) RotateNow(ctx context.Context, secret types.NamespacedName) error {
+	log := r.Log.WithValues("name", secret.Name, "namespace", secret.Namespace)
+	_, reason, err := r.reconcile(ctx, log, ctrl.Request{NamespacedName: secret}, true)
+	if err != nil {
+		return err
+	}
+	if reason != reasonTokenRotated && reason != reasonTokenIssued {
+		return fmt.Errorf("secret %s was not rotated: %s", secret, reason)
+	}
+	return nil
+}
+
+// ReconcileAll reconciles every secret with a valid autoprovision annotation
+// once, in the given garden namespaces or in all namespaces if none are given.
+// It returns the number of secrets by the reason their reconcile ended with
+// and an error joining all failed reconciles.
+func (r *SecretReconciler) ReconcileAll(ctx context.Context, namespaces ...string) (map[string]int, error) {
+	var requests []reconcile.Request
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	for _, namespace := range namespaces {
+		namespaceRequests, err := r.annotatedSecretRequests(ctx, func(target) bool { return true }, client.InNamespace(namespace))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list secrets: %w", err)
+		}
+		requests = append(requests, namespaceRequests...)
+	}
+	reasons := make(map[string]int)
+	var errs []error
+	for _, req := range requests {
+		log := r.Log.WithValues("name", req.Name, "namespace", req.Namespace)
+		_, reason, err := r.reconcile(ctx, log, req, false)
+		if err != nil {
+			reason = reasonError
+			errs = append(errs, fmt.Errorf("%s: %w", req.NamespacedName, err))
+		}
+		log.Info("reconcile finished", "reason", reason)
+		reasons[string(reason)]++
 	}
+	return reasons, errors.Join(errs...)
+}
+
+func (r *SecretReconciler) reconcile(ctx context.Context, log logr.Logger, req ctrl.Request, forceRotation bool) (result ctrl.Result, reason reconcileReason, err error) {
+	var identity string
+	defer func() {
+		if err == nil {
+			recordSuccessfulReconcile(identity)
+			if reason.skipped() {
+				secretsSkippedTotal.WithLabelValues(string(reason)).Inc()
+			}
+		}
+	}()
+	config := r.ConfigWatcher.Config()
 	var secret corev1.Secret
-	if err := r.GardenClient.Get(ctx, req.NamespacedName, &secret); err != nil {
+	getCtx, cancel := r.withClientTimeout(ctx)
+	defer cancel()
+	if err := r.GardenClient.Get(getCtx, req.NamespacedName, &secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, reasonSecretDeleted, nil
+		}
 		log.Error(err, "unable to fetch Secret")
-		return ctrl.Result{}, client.IgnoreNotFound(err)
+		return ctrl.Result{}, reasonError, err
+	}
+	if !secret.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, reasonSecretDeleted, r.finalize(ctx, log, config, &secret)
 	}
-	autoprovisionValue, ok := secret.Annotations[AutoprovisonAnnotationKey]
+	keys := r.AnnotationKeys.orDefault()
+	autoprovisionValue, ok := secret.Annotations[keys.Autoprovision]
 	if !ok {
-		log.Info("skkipping secret without autoprovision annotation")
-		return ctrl.Result{}, nil
+		if controllerutil.ContainsFinalizer(&secret, TokenRevocationFinalizer) {
+			return ctrl.Result{}, reasonNoAnnotation, r.removeFinalizer(ctx, &secret)
+		}
+		return ctrl.Result{}, reasonNoAnnotation, nil
+	}
+	if secret.Annotations[keys.AutoprovisionPaused] == "true" {
+		log.Info("skipping paused secret")
+		r.Recorder.Eventf(&secret, corev1.EventTypeNormal, EventReasonAutoprovisionPaused,
+			"autoprovisioning is paused by the %s annotation", keys.AutoprovisionPaused)
+		return ctrl.Result{}, reasonPaused, nil
 	}
 	target, err := parseAutoprovisionValue(autoprovisionValue)
 	if err != nil {
 		log.Info("skipping secret with invalid autoprovision annotation", "error", err)
-		return ctrl.Result{}, nil
-	}
-	// Find the config for the target identity
-	var cfgCluster ClusterConfig
-	for _, c := range config.Clusters {
-		if c.Identity == target.identity {
-			log.Info("found matching config for target identity", "identity", target.identity)
-			cfgCluster = c
-			break
-		}
+		r.Recorder.Event(&secret, corev1.EventTypeWarning, EventReasonInvalidAnnotation, err.Error())
+		return ctrl.Result{}, reasonInvalidAnnotation, nil
 	}
-	if cfgCluster.Identity == "" {
+	targetNamespaces, err := target.resolveNamespaces(&secret)
+	if err != nil {
+		log.Info("skipping secret with invalid autoprovision annotation", "error", err)
+		r.Recorder.Event(&secret, corev1.EventTypeWarning, EventReasonInvalidAnnotation, err.Error())
+		return ctrl.Result{}, reasonInvalidAnnotation, nil
+	}
+	cfgCluster, ok := config.Cluster(target.identity)
+	if !ok {
 		log.Info("skipping secret without matching config for target identity", "identity", target.identity)
-		return ctrl.Result{}, nil
+		r.Recorder.Eventf(&secret, corev1.EventTypeWarning, EventReasonNoMatchingConfig,
+			"no cluster config for identity %s", target.identity)
+		return ctrl.Result{}, reasonNoMatchingConfig, nil
 	}
-	metalClient := r.LocalClient
+	log.V(1).Info("found matching config for target identity", "identity", target.identity)
+	identity = target.identity
+	if r.TokenRotationStatus {
+		defer func() {
+			r.updateTokenRotation(ctx, log, &secret, target.identity, result, reason, err)
+		}()
+	}
+	metalClient, metalConfig := r.LocalClient, r.LocalConfig
 	if cfgCluster.TargetSecretName != "" && cfgCluster.TargetSecretNamespace != "" {
-		metalClient, err = makeTargetClient(ctx, r.LocalClient, types.NamespacedName{
+		targetCtx, cancel := r.withClientTimeout(ctx)
+		defer cancel()
+		metalClient, metalConfig, err = r.targetClients.get(targetCtx, r.LocalClient, types.NamespacedName{
 			Name:      cfgCluster.TargetSecretName,
 			Namespace: cfgCluster.TargetSecretNamespace,
-		})
+		}, cfgCluster.TargetKubeconfigContext)
+		var invalid *invalidKubeconfigError
+		if errors.As(err, &invalid) {
+			r.Recorder.Eventf(&secret, corev1.EventTypeWarning, EventReasonInvalidTargetKubeconfig,
+				"target secret %s/%s of identity %s: %s", cfgCluster.TargetSecretNamespace, cfgCluster.TargetSecretName, target.identity, invalid)
+		}
+		if err != nil {
+			log.Error(err, "unable to create metal cluster client")
+			return ctrl.Result{}, reasonError, err
+		}
+	}
+	// the service account namespaces may depend on the target namespace, so
+	// the config is resolved for each of them
+	var targets []namespaceTarget
+	var serviceAccountNamespaces []string
+	for _, namespace := range targetNamespaces {
+		namespaceConfig := cfgCluster
+		data := newTemplateData(&secret)
+		data.TargetNamespace = namespace
+		rendered, err := namespaceConfig.resolveServiceAccountNamespaces(data)
+		if err != nil {
+			log.Error(err, "unable to resolve service account namespace")
+			return ctrl.Result{}, reasonError, reconcile.TerminalError(err)
+		}
+		for _, namespace := range rendered {
+			if !slices.Contains(serviceAccountNamespaces, namespace) {
+				serviceAccountNamespaces = append(serviceAccountNamespaces, namespace)
+			}
+		}
+		t := namespaceTarget{namespace: namespace, config: &namespaceConfig}
+		if len(targetNamespaces) > 1 {
+			t.keySuffix = "." + namespace
+		}
+		targets = append(targets, t)
+	}
+	if cfgCluster.RestrictToServiceAccountNamespace {
+		// all requests are made in the namespace of the first token, so every
+		// token of every target namespace has to come from it
+		namespace := targets[0].config.ServiceAccountNamespace
+		for _, t := range targets {
+			for _, spec := range t.config.tokenSpecs() {
+				if spec.serviceAccount.Namespace == namespace {
+					continue
+				}
+				err := fmt.Errorf("restrictToServiceAccountNamespace requires the same service account namespace for all tokens and namespaces of the autoprovision annotation, but key %s uses %s instead of %s",
+					spec.key+t.keySuffix, spec.serviceAccount.Namespace, namespace)
+				log.Error(err, "unable to resolve service account namespace")
+				return ctrl.Result{}, reasonError, reconcile.TerminalError(err)
+			}
+		}
+		// the namespace cannot be checked without access to namespaces
+		serviceAccountNamespaces = nil
+	}
+	for _, namespace := range serviceAccountNamespaces {
+		exists, err := r.namespaceExists(ctx, metalClient, namespace)
+		if err == nil && !exists {
+			err = fmt.Errorf("service account namespace %s does not exist in the metal cluster", namespace)
+		}
+		if err != nil {
+			log.Error(err, "unable to verify service account namespace")
+			return ctrl.Result{}, reasonError, err
+		}
+	}
+	for _, targetNamespace := range targetNamespaces {
+		if !cfgCluster.VerifyTargetNamespace {
+			break
+		}
+		exists, err := r.namespaceExists(ctx, metalClient, targetNamespace)
+		if err != nil {
+			log.Error(err, "unable to verify target namespace")
+			return ctrl.Result{}, reasonError, err
+		}
+		if !exists {
+			log.Info("skipping secret whose target namespace does not exist", "targetNamespace", targetNamespace)
+			r.Recorder.Eventf(&secret, corev1.EventTypeWarning, EventReasonTargetNamespaceNotFound,
+				"target namespace %s does not exist in the metal cluster of identity %s", targetNamespace, target.identity)
+			_, fallback := r.requeueIntervals(&cfgCluster)
+			return ctrl.Result{RequeueAfter: fallback}, reasonTargetNamespaceNotFound, nil
+		}
+	}
+	if cfgCluster.ImpersonateUser != "" {
+		impersonationCtx, cancel := r.withClientTimeout(ctx)
+		defer cancel()
+		metalClient, err = r.impersonatingClients.get(impersonationCtx, &cfgCluster, metalClient, metalConfig)
 		if err != nil {
-			log.Error(err, "failed to create metal cluster client")
-			return ctrl.Result{}, err
+			log.Error(err, "unable to create impersonating metal cluster client")
+			return ctrl.Result{}, reasonError, err
 		}
 	}
+	if cfgCluster.RestrictToServiceAccountNamespace {
+		metalClient = client.NewNamespacedClient(metalClient, targets[0].config.ServiceAccountNamespace)
+	}
 	return r.reconcileInternal(ctx, &secret, ReconcileParams{
-		config:          &cfgCluster,
-		metalClient:     metalClient,
-		targetNamespace: target.namespace,
+		config:                 targets[0].config,
+		metalClient:            metalClient,
+		metalConfig:            metalConfig,
+		targets:                targets,
+		forceRotation:          forceRotation,
+		allowedServiceAccounts: config.AllowedServiceAccounts,
 	})
 }
 
+// finalize handles the deletion of a managed secret. Tokens bound to the
+// secret are invalidated by the API server once the secret is gone. Other
+// tokens cannot be revoked without deleting the service account, so they stay
+// valid until they expire.
+func (r *SecretReconciler) finalize(ctx context.Context, log logr.Logger, config *Config, secret *corev1.Secret) error {
+	if !controllerutil.ContainsFinalizer(secret, TokenRevocationFinalizer) {
+		return nil
+	}
+	keys := r.AnnotationKeys.orDefault()
+	bound := false
+	if target, err := parseAutoprovisionValue(secret.Annotations[keys.Autoprovision]); err == nil {
+		if cfgCluster, ok := config.Cluster(target.identity); ok {
+			bound = cfgCluster.BindToSecret
+		}
+	}
+	if bound {
+		log.Info("secret deleted, bound token is invalidated with it")
+	} else {
+		log.Info("secret deleted, token stays valid until it expires", "expiresAt", secret.Annotations[keys.TokenExpiresAt])
+	}
+	return r.removeFinalizer(ctx, secret)
+}
+
+func (r *SecretReconciler) removeFinalizer(ctx context.Context, secret *corev1.Secret) error {
+	unmodifiedSecret := secret.DeepCopy()
+	controllerutil.RemoveFinalizer(secret, TokenRevocationFinalizer)
+	ctx, cancel := r.withClientTimeout(ctx)
+	defer cancel()
+	if err := r.GardenClient.Patch(ctx, secret, client.MergeFrom(unmodifiedSecret)); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	return nil
+}
+
 type ReconcileParams struct {
-	config          *ClusterConfig
-	metalClient     client.Client
-	targetNamespace string
+	// config is the config resolved for the first target
+	config      *ClusterConfig
+	metalClient client.Client
+	metalConfig *rest.Config
+	// targets are the namespaces of the autoprovision annotation
+	targets []namespaceTarget
+	// forceRotation replaces all tokens, even if they are still valid
+	forceRotation bool
+	// allowedServiceAccounts are the patterns of Config.AllowedServiceAccounts
+	allowedServiceAccounts []string
+}
+
+// namespaceTarget is a namespace of the autoprovision annotation with the
+// cluster config resolved for it.
+type namespaceTarget struct {
+	namespace string
+	config    *ClusterConfig
+	// keySuffix is appended to the keys written for the namespace, so that the
+	// tokens of several namespaces can share a secret. It is empty for
+	// annotations with a single namespace.
+	keySuffix string
 }
 
-func (r *SecretReconciler) reconcileInternal(ctx context.Context, secret *corev1.Secret, params ReconcileParams) (ctrl.Result, error) {
+// tokenSpecs returns the tokens of all targets with their key suffixes.
+func (p *ReconcileParams) tokenSpecs() []tokenSpec {
+	var specs []tokenSpec
+	for _, target := range p.targets {
+		for _, spec := range target.config.tokenSpecs() {
+			spec.key += target.keySuffix
+			specs = append(specs, spec)
+		}
+	}
+	return specs
+}
+
+func (r *SecretReconciler) reconcileInternal(ctx context.Context, secret *corev1.Secret, params ReconcileParams) (ctrl.Result, reconcileReason, error) {
 	log := r.Log.WithValues("name", secret.Name, "namespace", secret.Namespace)
+	if wantType := params.config.SecretType; wantType != "" && secret.Type != wantType {
+		err := fmt.Errorf("secret has type %q instead of %q, the type of a secret cannot be changed, so it has to be recreated", secret.Type, wantType)
+		log.Error(err, "unable to manage secret of wrong type")
+		r.Recorder.Event(secret, corev1.EventTypeWarning, EventReasonSecretTypeMismatch, err.Error())
+		return ctrl.Result{}, reasonError, reconcile.TerminalError(err)
+	}
+	secretKey := client.ObjectKeyFromObject(secret)
+	annotationKeys := r.AnnotationKeys.orDefault()
+	generation := strconv.FormatInt(params.config.RotationGeneration, 10)
+	force := params.forceRotation
+	if params.config.RotationGeneration > 0 && secret.Annotations[annotationKeys.RotationGeneration] != generation {
+		log.Info("rotating tokens for new rotation generation", "generation", generation)
+		force = true
+	}
+	if !force && r.rotationLoops.looping(secretKey, Now()) {
+		log.Info("WARNING: secret was rotated too often, backing off", "rotations", rotationLoopLimit, "window", rotationLoopWindow, "backoff", rotationLoopBackoff)
+		r.Recorder.Eventf(secret, corev1.EventTypeWarning, EventReasonRotationLoopDetected,
+			"tokens were replaced before they were due %d times within %s, not rotating them again for %s, check that new tokens pass the TokenReview",
+			rotationLoopLimit, rotationLoopWindow, rotationLoopBackoff)
+		return ctrl.Result{RequeueAfter: rotationLoopBackoff}, reasonRotationLoop, nil
+	}
 	unmodifiedSecret := secret.DeepCopy()
 	if secret.Data == nil {
 		secret.Data = make(map[string][]byte)
 	}
-	token, err := r.ensureToken(ctx, ensureTokenParams{
-		metalClient: params.metalClient,
-		log:         log,
-		serviceAccount: types.NamespacedName{
-			Name:      params.config.ServiceAccountName,
-			Namespace: params.config.ServiceAccountNamespace,
-		},
-		expirationSecods: params.config.ExpirationSeconds,
-		currentToken:     string(secret.Data["token"]),
-	})
-	if err != nil {
-		log.Error(err, "unable to ensure token")
-		return ctrl.Result{}, err
+	identity := params.config.Identity
+	keys := params.config.SecretKeys
+	var rotations []tokenRotation
+	var prematureRotations int
+	tokens := make(map[string]string)
+	var expiresAt, renewAt time.Time
+	var rateLimited bool
+	thresholdPercent := params.config.renewalThresholdPercentFor(secret.UID)
+	var renewAfter time.Time
+	if value, ok := secret.Annotations[annotationKeys.RenewAfter]; ok {
+		var err error
+		if renewAfter, err = time.Parse(time.RFC3339, value); err != nil {
+			log.Info("ignoring invalid renew-after annotation", "annotation", annotationKeys.RenewAfter, "error", err.Error())
+			r.Recorder.Eventf(secret, corev1.EventTypeWarning, EventReasonInvalidRenewAfter,
+				"ignoring annotation %s, which is not an RFC 3339 time: %q", annotationKeys.RenewAfter, value)
+		}
+	}
+	ceiling, fallback := r.requeueIntervals(params.config)
+	requeue := ceiling
+	for _, spec := range params.tokenSpecs() {
+		currentToken, err := decodeToken(secret.Data[spec.key], params.config.Base64EncodeTokens)
+		tokenForce := force
+		if err != nil {
+			log.Info("token is not base64-encoded, rotating", "key", spec.key)
+			tokenForce = true
+		}
+		token, err := r.ensureToken(ctx, ensureTokenParams{
+			metalClient:             params.metalClient,
+			log:                     log.WithValues("key", spec.key),
+			secret:                  secret,
+			key:                     spec.key,
+			identity:                identity,
+			serviceAccount:          spec.serviceAccount,
+			expirationSeconds:       spec.expirationSeconds,
+			renewalThresholdPercent: thresholdPercent,
+			audiences:               params.config.Audiences,
+			skipTokenReview:         params.config.SkipTokenReview,
+			bindToSecret:            params.config.BindToSecret,
+			currentToken:            currentToken,
+			renewAfter:              renewAfter,
+			maxTokenAge:             params.config.maxTokenAge(),
+			force:                   tokenForce,
+			allowedServiceAccounts:  params.allowedServiceAccounts,
+			maxTokensPerMinute:      params.config.MaxTokensPerMinute,
+			createServiceAccount:    params.config.CreateServiceAccountIfMissing,
+		})
+		var limited *rateLimitedError
+		if errors.As(err, &limited) {
+			log.Info("token issuance is rate limited, keeping the current token", "key", spec.key, "retryAfter", limited.retryAfter)
+			r.Recorder.Event(secret, corev1.EventTypeWarning, EventReasonTokenIssuanceRateLimited, limited.Error())
+			rateLimited = true
+			requeue = min(requeue, limited.retryAfter)
+			token, err = currentToken, nil
+		}
+		var notFound *serviceAccountNotFoundError
+		if errors.As(err, &notFound) {
+			tokenRotationsTotal.WithLabelValues(identity, resultError).Inc()
+			log.Info("WARNING: service account does not exist in the metal cluster", "key", spec.key, "serviceAccount", notFound.serviceAccount)
+			r.Recorder.Eventf(secret, corev1.EventTypeWarning, EventReasonServiceAccountNotFound,
+				"cannot request a token for identity %s: %s", identity, notFound)
+			return ctrl.Result{RequeueAfter: serviceAccountNotFoundRequeueAfter}, reasonServiceAccountNotFound, nil
+		}
+		if err != nil {
+			tokenRotationsTotal.WithLabelValues(identity, resultError).Inc()
+			log.Error(err, "unable to ensure token", "key", spec.key)
+			return ctrl.Result{}, reasonError, err
+		}
+		if token != currentToken {
+			issued := IssuedToken{Key: spec.key, Token: token, Identity: identity, ServiceAccount: spec.serviceAccount}
+			if claims, err := parseTokenClaims(token); err == nil {
+				issued.ExpiresAt = claims.expiresAt()
+			}
+			if err := r.storeToken(ctx, secret, params.config, issued); err != nil {
+				tokenRotationsTotal.WithLabelValues(identity, resultError).Inc()
+				log.Error(err, "unable to store token", "key", spec.key)
+				return ctrl.Result{}, reasonError, err
+			}
+			rotations = append(rotations, tokenRotation{key: spec.key, token: token, issued: currentToken == ""})
+			// forced rotations are intended, however often they happen
+			if !tokenForce && r.prematureRotation(currentToken, thresholdPercent, params.config.maxTokenAge()) {
+				prematureRotations++
+			}
+		}
+		tokens[spec.key] = token
+		tokenRequeue := requeueAfter(token, thresholdPercent, r.MinRotationInterval, r.ClockSkewTolerance, fallback, ceiling)
+		if claims, err := parseTokenClaims(token); err == nil {
+			if expiresAt.IsZero() || claims.expiresAt().Before(expiresAt) {
+				expiresAt = claims.expiresAt()
+			}
+			tokenRenewAt := claims.issuedAt().Add(claims.renewalAge(thresholdPercent, r.MinRotationInterval))
+			// a token pinned by renewAfter is rotated once it passes, or
+			// once the token expires
+			pinnedUntil := renewAfter
+			if expiry := claims.expiresAt().Add(-r.ClockSkewTolerance); expiry.Before(pinnedUntil) {
+				pinnedUntil = expiry
+			}
+			if pinnedUntil.After(tokenRenewAt) {
+				tokenRenewAt = pinnedUntil
+				tokenRequeue = min(max(pinnedUntil.Sub(Now()), minRequeueAfter), ceiling)
+			}
+			if maxAge := params.config.maxTokenAge(); maxAge > 0 {
+				if ageLimit := claims.issuedAt().Add(maxAge); ageLimit.Before(tokenRenewAt) {
+					tokenRenewAt = ageLimit
+					tokenRequeue = min(tokenRequeue, max(ageLimit.Add(-r.ClockSkewTolerance).Sub(Now()), minRequeueAfter))
+				}
+			}
+			if renewAt.IsZero() || tokenRenewAt.Before(renewAt) {
+				renewAt = tokenRenewAt
+			}
+		}
+		requeue = min(requeue, tokenRequeue)
+	}
+	primaryToken := tokens[keys.TokenKey+params.targets[0].keySuffix]
+	secret.Data[keys.UsernameKey] = []byte(params.config.ServiceAccountName)
+	for _, target := range params.targets {
+		secret.Data[keys.NamespaceKey+target.keySuffix] = []byte(target.namespace)
+		if params.config.EmitKubeconfig {
+			kubeconfig, err := buildKubeconfig(params.metalConfig, target.namespace, tokens[keys.TokenKey+target.keySuffix])
+			if err != nil {
+				log.Error(err, "unable to build kubeconfig")
+				return ctrl.Result{}, reasonError, err
+			}
+			secret.Data["kubeconfig"+target.keySuffix] = kubeconfig
+		}
+	}
+	// keys without suffix are left over from an annotation with a single
+	// namespace, and their tokens would stay valid without being rotated
+	if len(params.targets) > 1 {
+		for _, spec := range params.config.tokenSpecs() {
+			delete(secret.Data, spec.key)
+		}
+		delete(secret.Data, keys.NamespaceKey)
+		if params.config.EmitKubeconfig {
+			delete(secret.Data, "kubeconfig")
+		}
+	}
+	if params.config.EmitCACert {
+		ca, err := caCert(params.metalConfig)
+		if err != nil {
+			log.Error(err, "unable to read the CA certificate of the metal cluster")
+			return ctrl.Result{}, reasonError, err
+		}
+		secret.Data[caCertKey] = ca
+	}
+	if claims, err := parseTokenClaims(primaryToken); err == nil {
+		if secret.Annotations == nil {
+			secret.Annotations = make(map[string]string)
+		}
+		secret.Annotations[annotationKeys.TokenIssuedAt] = claims.issuedAt().UTC().Format(time.RFC3339)
+		secret.Annotations[annotationKeys.TokenExpiresAt] = claims.expiresAt().UTC().Format(time.RFC3339)
+	}
+	// a rate limited token is rotated for the generation on the next reconcile
+	if params.config.RotationGeneration > 0 && !rateLimited {
+		if secret.Annotations == nil {
+			secret.Annotations = make(map[string]string)
+		}
+		secret.Annotations[annotationKeys.RotationGeneration] = generation
+	}
+	if secret.Labels == nil {
+		secret.Labels = make(map[string]string)
+	}
+	maps.Copy(secret.Labels, params.config.Labels)
+	secret.Labels[ManagedByLabelKey] = ManagedByLabelValue
+	controllerutil.AddFinalizer(secret, TokenRevocationFinalizer)
+	// an unchanged secret is not patched, so that its resourceVersion stays
+	// the same and the write does not trigger another reconcile
+	var err error
+	if equality.Semantic.DeepEqual(unmodifiedSecret, secret) {
+		log.V(1).Info("secret is up to date, skipping patch")
+	} else {
+		if log := log.V(1); log.Enabled() {
+			changes := dataChanges(unmodifiedSecret.Data, secret.Data)
+			var tokenKeys, namespaceKeys []string
+			for _, spec := range params.tokenSpecs() {
+				tokenKeys = append(tokenKeys, spec.key)
+			}
+			for _, target := range params.targets {
+				namespaceKeys = append(namespaceKeys, keys.NamespaceKey+target.keySuffix)
+			}
+			log.Info("patching secret", "changes", changes, "tokenRotated", changesKey(changes, tokenKeys...),
+				"namespaceChanged", changesKey(changes, namespaceKeys...), "usernameChanged", changesKey(changes, keys.UsernameKey))
+		}
+		patchCtx, cancel := r.withClientTimeout(ctx)
+		defer cancel()
+		err = r.GardenClient.Patch(patchCtx, secret, client.MergeFrom(unmodifiedSecret))
+	}
+	if apierrors.IsConflict(err) {
+		// the secret is reconciled again with its current state, which
+		// usually succeeds, so this is neither logged nor counted as an error
+		log.Info("secret was modified concurrently, retrying", "error", err.Error())
+		return ctrl.Result{RequeueAfter: conflictRequeueAfter}, reasonConflict, nil
 	}
-	secret.Data["token"] = []byte(token)
-	secret.Data["username"] = []byte(params.config.ServiceAccountName)
-	secret.Data["namespace"] = []byte(params.targetNamespace)
-	err = r.GardenClient.Patch(ctx, secret, client.MergeFrom(unmodifiedSecret))
 	if err != nil {
+		tokenRotationsTotal.WithLabelValues(identity, resultError).Add(float64(len(rotations)))
 		log.Error(err, "unable to patch Secret")
-		return ctrl.Result{}, err
+		return ctrl.Result{}, reasonError, err
+	}
+	if prematureRotations > 0 {
+		r.rotationLoops.record(secretKey, Now())
 	}
-	return ctrl.Result{RequeueAfter: 2 * time.Minute}, nil
+	if !expiresAt.IsZero() {
+		tokenExpiries.set(secretKey, tokenExpiry{
+			identity:                identity,
+			expiresAt:               expiresAt,
+			renewalThresholdPercent: thresholdPercent,
+			renewAt:                 renewAt,
+		})
+	}
+	for _, rotation := range rotations {
+		tokenRotationsTotal.WithLabelValues(identity, resultSuccess).Inc()
+		r.recordTokenEvent(secret, identity, rotation)
+	}
+	reason := reasonTokenFresh
+	if rateLimited {
+		reason = reasonRateLimited
+	}
+	if len(rotations) > 0 {
+		reason = reasonTokenRotated
+		if rotations[0].issued {
+			reason = reasonTokenIssued
+		}
+	}
+	return ctrl.Result{RequeueAfter: r.jitter(requeue)}, reason, nil
+}
+
+// tokenRotation records a token that was replaced during a reconcile.
+type tokenRotation struct {
+	key    string
+	token  string
+	issued bool
+}
+
+func (r *SecretReconciler) recordTokenEvent(secret *corev1.Secret, identity string, rotation tokenRotation) {
+	reason, verb := EventReasonTokenRotated, "rotated"
+	if rotation.issued {
+		reason, verb = EventReasonTokenIssued, "issued"
+	}
+	claims, err := parseTokenClaims(rotation.token)
+	if err != nil {
+		r.Recorder.Eventf(secret, corev1.EventTypeNormal, reason, "%s token %q for identity %s", verb, rotation.key, identity)
+		return
+	}
+	r.Recorder.Eventf(secret, corev1.EventTypeNormal, reason, "%s token %q for identity %s, expires at %s",
+		verb, rotation.key, identity, claims.expiresAt().UTC().Format(time.RFC3339))
+}
+
+func (r *SecretReconciler) defaultRequeue() time.Duration {
+	if r.DefaultRequeue <= 0 {
+		return DefaultRequeueAfter
+	}
+	return r.DefaultRequeue
+}
+
+// requeueIntervals returns the longest requeue interval for the secrets of
+// cluster and the one for tokens whose expiry cannot be determined.
+func (r *SecretReconciler) requeueIntervals(cluster *ClusterConfig) (ceiling, fallback time.Duration) {
+	if cluster.RequeueIntervalSeconds > 0 {
+		interval := time.Duration(cluster.RequeueIntervalSeconds) * time.Second
+		return interval, interval
+	}
+	return maxRequeueAfter, r.defaultRequeue()
+}
+
+// auditTokenIssued records an issued token in the AuditSink. Failures are
+// only logged, the token has been issued at this point either way.
+func (r *SecretReconciler) auditTokenIssued(ctx context.Context, params ensureTokenParams, tokenRequest *authenticationv1.TokenRequest) {
+	if r.AuditSink == nil {
+		return
+	}
+	// audiences are taken from the response, which has the API server defaults
+	record := AuditRecord{
+		Time:                    Now(),
+		SecretNamespace:         params.secret.Namespace,
+		SecretName:              params.secret.Name,
+		TokenKey:                params.key,
+		Identity:                params.identity,
+		ServiceAccountNamespace: params.serviceAccount.Namespace,
+		ServiceAccountName:      params.serviceAccount.Name,
+		Audiences:               tokenRequest.Spec.Audiences,
+		ExpiresAt:               tokenRequest.Status.ExpirationTimestamp.Time,
+	}
+	if claims, err := parseTokenClaims(tokenRequest.Status.Token); err == nil {
+		record.IssuedAt = claims.issuedAt()
+		record.ExpiresAt = claims.expiresAt()
+	}
+	if err := r.AuditSink.TokenIssued(ctx, record); err != nil {
+		params.log.Error(err, "unable to record issued token in audit sink")
+	}
+}
+
+// withClientTimeout bounds a single call to the garden or metal cluster. An
+// expired deadline fails the reconcile with a regular error, so it is retried
+// with backoff like any other unavailability of the API server.
+func (r *SecretReconciler) withClientTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.ClientTimeout <= 0 {
+		return context.WithTimeout(ctx, DefaultClientTimeout)
+	}
+	return context.WithTimeout(ctx, r.ClientTimeout)
+}
+
+func (r *SecretReconciler) jitter(d time.Duration) time.Duration {
+	if r.RequeueJitterPercent <= 0 {
+		return d
+	}
+	return d + time.Duration(float64(d)*float64(r.RequeueJitterPercent)/100*RandFloat64())
 }
 
 type target struct {
-	identity  string
-	namespace string
+	identity string
+	// namespaces are plain namespaces or templates, see templateData
+	namespaces []string
 }
 
+// parseAutoprovisionValue parses an annotation value of the form
+// <identity>/<namespace>[,<namespace>...]. The identity has to be a DNS
+// subdomain and each namespace a valid namespace name or a template for one,
+// see templateData.
 func parseAutoprovisionValue(value string) (target, error) {
-	parts := strings.Split(value, "/")
-	if len(parts) != 2 {
-		return target{}, fmt.Errorf("invalid autoprovision annotation value: %s", value)
+	identity, namespaces, ok := strings.Cut(value, "/")
+	if !ok {
+		return target{}, fmt.Errorf("invalid autoprovision annotation %q: expected <identity>/<namespace>", value)
+	}
+	if strings.Contains(namespaces, "/") {
+		return target{}, fmt.Errorf("invalid autoprovision annotation %q: expected exactly one slash", value)
+	}
+	if identity == "" {
+		return target{}, fmt.Errorf("invalid autoprovision annotation %q: identity is empty", value)
+	}
+	if errs := validation.IsDNS1123Subdomain(identity); len(errs) > 0 {
+		return target{}, fmt.Errorf("invalid autoprovision annotation %q: invalid identity: %s", value, strings.Join(errs, ", "))
+	}
+	t := target{identity: identity}
+	for namespace := range strings.SplitSeq(namespaces, ",") {
+		if namespace == "" {
+			return target{}, fmt.Errorf("invalid autoprovision annotation %q: namespace is empty", value)
+		}
+		if slices.Contains(t.namespaces, namespace) {
+			return target{}, fmt.Errorf("invalid autoprovision annotation %q: namespace %q is listed twice", value, namespace)
+		}
+		if isTemplate(namespace) {
+			// validated once it is rendered for a secret
+			if _, err := parseTemplate(namespace); err != nil {
+				return target{}, fmt.Errorf("invalid autoprovision annotation %q: invalid namespace template: %w", value, err)
+			}
+		} else if err := validateNamespace(namespace); err != nil {
+			return target{}, fmt.Errorf("invalid autoprovision annotation %q: %w", value, err)
+		}
+		t.namespaces = append(t.namespaces, namespace)
+	}
+	return t, nil
+}
+
+// resolveNamespaces renders the namespace templates of the annotation for secret.
+func (t target) resolveNamespaces(secret *corev1.Secret) ([]string, error) {
+	resolved := make([]string, 0, len(t.namespaces))
+	for _, namespace := range t.namespaces {
+		namespace, err := renderTemplate(namespace, newTemplateData(secret))
+		if err != nil {
+			return nil, fmt.Errorf("invalid autoprovision annotation: %w", err)
+		}
+		if err := validateNamespace(namespace); err != nil {
+			return nil, fmt.Errorf("invalid autoprovision annotation: %w", err)
+		}
+		if slices.Contains(resolved, namespace) {
+			return nil, fmt.Errorf("invalid autoprovision annotation: namespace %q is listed twice", namespace)
+		}
+		resolved = append(resolved, namespace)
 	}
-	return target{identity: parts[0], namespace: parts[1]}, nil
+	return resolved, nil
+}
+
+func validateNamespace(namespace string) error {
+	if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+		return fmt.Errorf("invalid namespace %q: %s", namespace, strings.Join(errs, ", "))
+	}
+	return nil
 }
 
 type ensureTokenParams struct {
-	metalClient      client.Client
-	log              logr.Logger
-	serviceAccount   types.NamespacedName
-	expirationSecods int64
-	currentToken     string
+	metalClient             client.Client
+	log                     logr.Logger
+	secret                  *corev1.Secret
+	key                     string
+	identity                string
+	serviceAccount          types.NamespacedName
+	expirationSeconds       int64
+	renewalThresholdPercent int64
+	audiences               []string
+	skipTokenReview         bool
+	bindToSecret            bool
+	currentToken            string
+	renewAfter              time.Time
+	maxTokenAge             time.Duration
+	force                   bool
+	allowedServiceAccounts  []string
+	maxTokensPerMinute      int64
+	// createServiceAccount creates the service account if it is missing
+	createServiceAccount bool
 }
 
 func (r *SecretReconciler) ensureToken(ctx context.Context, params ensureTokenParams) (string, error) {
-	needsToken, err := r.needsToken(ctx, params.log, params.currentToken, params.metalClient)
-	if err != nil {
-		return "", fmt.Errorf("failed to check if token is needed: %w", err)
+	needsToken := params.force
+	if !needsToken {
+		var err error
+		needsToken, err = r.needsToken(ctx, params)
+		if err != nil {
+			return "", fmt.Errorf("failed to check if token is needed: %w", err)
+		}
 	}
 	if !needsToken {
 		return params.currentToken, nil
 	}
+	if !serviceAccountAllowed(params.allowedServiceAccounts, params.serviceAccount) {
+		err := fmt.Errorf("service account %s is not in allowedServiceAccounts", params.serviceAccount)
+		r.Recorder.Eventf(params.secret, corev1.EventTypeWarning, EventReasonServiceAccountNotAllowed,
+			"refusing to request a token for identity %s: %s", params.identity, err)
+		return "", reconcile.TerminalError(err)
+	}
+	if retryAfter := r.issuanceLimiters.reserve(params.identity, params.maxTokensPerMinute); retryAfter > 0 {
+		return "", &rateLimitedError{identity: params.identity, retryAfter: retryAfter}
+	}
 	var account corev1.ServiceAccount
 	account.Name = params.serviceAccount.Name
 	account.Namespace = params.serviceAccount.Namespace
 	var tokenRequest authenticationv1.TokenRequest
-	tokenRequest.Spec.ExpirationSeconds = &params.expirationSecods
-	if err := params.metalClient.SubResource("token").Create(ctx, &account, &tokenRequest); err != nil {
+	tokenRequest.Spec.ExpirationSeconds = &params.expirationSeconds
+	tokenRequest.Spec.Audiences = params.audiences
+	if params.bindToSecret {
+		tokenRequest.Spec.BoundObjectRef = &authenticationv1.BoundObjectReference{
+			Kind:       "Secret",
+			APIVersion: "v1",
+			Name:       params.secret.Name,
+			UID:        params.secret.UID,
+		}
+	}
+	start := time.Now()
+	ctx, cancel := r.withClientTimeout(ctx)
+	defer cancel()
+	tokenClient := r.tokenClient(params.metalClient)
+	err := tokenClient.CreateToken(ctx, &account, &tokenRequest)
+	if apierrors.IsNotFound(err) && params.createServiceAccount {
+		err = r.createServiceAccount(ctx, params)
+		if err == nil {
+			err = tokenClient.CreateToken(ctx, &account, &tokenRequest)
+		}
+	}
+	tokenCreationDuration.WithLabelValues(params.identity).Observe(time.Since(start).Seconds())
+	if apierrors.IsNotFound(err) {
+		return "", &serviceAccountNotFoundError{serviceAccount: params.serviceAccount}
+	}
+	if apierrors.IsForbidden(err) {
+		return "", fmt.Errorf("not allowed to request tokens for service account %s, grant create on serviceaccounts/token in namespace %s of the metal cluster: %w",
+			params.serviceAccount, params.serviceAccount.Namespace, err)
+	}
+	if err != nil {
 		return "", fmt.Errorf("failed to create token request: %w", err)
 	}
-	r.Log.Info("issued token")
+	params.log.Info("issued token")
+	r.auditTokenIssued(ctx, params, &tokenRequest)
+	// rotation is based on the claims of the issued token, but a capped
+	// lifetime usually means that the config asks for too much
+	requested := time.Duration(params.expirationSeconds) * time.Second
+	if claims, err := parseTokenClaims(tokenRequest.Status.Token); err == nil && lifetimeDiverges(requested, claims.lifetime()) {
+		params.log.Info("WARNING: issued token lifetime differs from the requested one", "identity", params.identity,
+			"requested seconds", requested.Seconds(), "actual seconds", claims.lifetime().Seconds())
+		r.Recorder.Eventf(params.secret, corev1.EventTypeWarning, EventReasonTokenLifetimeMismatch,
+			"token for identity %s is valid for %s instead of the requested %s, the metal cluster probably caps token lifetimes",
+			params.identity, claims.lifetime(), requested)
+	}
 	return tokenRequest.Status.Token, nil
 }
 
-type jwtClaims struct {
-	Exp int64 `json:"exp"`
-	Iat int64 `json:"iat"`
+// serviceAccountNotFoundError is returned by ensureToken if the service
+// account of a token does not exist in the metal cluster.
+type serviceAccountNotFoundError struct {
+	serviceAccount types.NamespacedName
+}
+
+func (e *serviceAccountNotFoundError) Error() string {
+	return fmt.Sprintf("service account %s does not exist in the metal cluster", e.serviceAccount)
+}
+
+// createServiceAccount creates the service account of a token in the metal
+// cluster. It is labeled like managed secrets, but never deleted.
+func (r *SecretReconciler) createServiceAccount(ctx context.Context, params ensureTokenParams) error {
+	account := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      params.serviceAccount.Name,
+			Namespace: params.serviceAccount.Namespace,
+			Labels:    map[string]string{ManagedByLabelKey: ManagedByLabelValue},
+		},
+	}
+	if err := params.metalClient.Create(ctx, account); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create service account %s: %w", params.serviceAccount, err)
+	}
+	params.log.Info("created missing service account", "serviceAccount", params.serviceAccount)
+	r.Recorder.Eventf(params.secret, corev1.EventTypeNormal, EventReasonServiceAccountCreated,
+		"created missing service account %s for identity %s", params.serviceAccount, params.identity)
+	return nil
 }
 
-func (r *SecretReconciler) needsToken(ctx context.Context, log logr.Logger, currentToken string, metalClient client.Client) (bool, error) {
-	if currentToken == "" {
+func (r *SecretReconciler) needsToken(ctx context.Context, params ensureTokenParams) (bool, error) {
+	currentToken := params.currentToken
+	// empty and whitespace-only values are left by consumers creating the
+	// secret, and are never worth a TokenReview
+	if strings.TrimSpace(currentToken) == "" {
+		params.log.V(1).Info("secret has no token")
+		return true, nil
+	}
+	if strings.TrimSpace(currentToken) != currentToken {
+		params.log.Info("token has surrounding whitespace, rotating")
+		return true, nil
+	}
+	claims, err := parseTokenClaims(currentToken)
+	if err != nil {
+		params.log.Error(err, "cannot determine token age, rotating to be safe")
+		return true, nil
+	}
+	age := Now().Sub(claims.issuedAt())
+	if params.maxTokenAge > 0 && age+r.ClockSkewTolerance > params.maxTokenAge {
+		params.log.Info("token is older than maxTokenAge, rotating", "age", age.String(), "maxTokenAge", params.maxTokenAge.String())
 		return true, nil
 	}
+	if now := Now(); now.Before(params.renewAfter) && now.Add(r.ClockSkewTolerance).Before(claims.expiresAt()) {
+		params.log.V(1).Info("token rotation is deferred by the renew-after annotation", "renewAfter", params.renewAfter)
+		return false, nil
+	}
+	renewalAge := claims.renewalAge(params.renewalThresholdPercent, r.MinRotationInterval)
+	params.log.V(1).Info("token info", "age seconds", age.Seconds(), "lifetime seconds", claims.lifetime().Seconds())
+	// a token issued in the future is suspicious, so it is always reviewed
+	if age >= -r.ClockSkewTolerance && age+r.ClockSkewTolerance < renewalAge*reviewWindowPercent/100 {
+		params.log.V(1).Info("skipping token review for token well within its renewal threshold")
+		return false, nil
+	}
+	if params.skipTokenReview {
+		if _, logged := r.tokenReviewSkipped.LoadOrStore(params.identity, struct{}{}); !logged {
+			params.log.Info("token review is disabled, validating tokens by their claims only", "identity", params.identity)
+		}
+		return age+r.ClockSkewTolerance > renewalAge, nil
+	}
 	var tokenReview authenticationv1.TokenReview
 	tokenReview.Spec.Token = currentToken
-	if err := metalClient.Create(ctx, &tokenReview); err != nil {
+	tokenReview.Spec.Audiences = params.audiences
+	ctx, cancel := r.withClientTimeout(ctx)
+	defer cancel()
+	err = r.tokenClient(params.metalClient).ReviewToken(ctx, &tokenReview)
+	switch {
+	case apierrors.IsForbidden(err):
+		// fall back to checking the token age only
+		if _, warned := r.tokenReviewForbidden.LoadOrStore(params.identity, struct{}{}); !warned {
+			params.log.Info("WARNING: not allowed to create TokenReviews, validating tokens by their expiry only", "identity", params.identity, "error", err.Error())
+		}
+	case err != nil:
+		tokenReviewFailuresTotal.WithLabelValues(params.identity).Inc()
+		r.Recorder.Eventf(params.secret, corev1.EventTypeWarning, EventReasonTokenReviewFailed,
+			"token review for identity %s failed: %s", params.identity, err)
 		return false, fmt.Errorf("failed to create token review: %w", err)
-	}
-	if !tokenReview.Status.Authenticated {
+	case !tokenReview.Status.Authenticated:
+		return true, nil
+	case len(params.audiences) > 0 && !slices.ContainsFunc(tokenReview.Status.Audiences, func(audience string) bool {
+		return slices.Contains(params.audiences, audience)
+	}):
+		// the API server only accepted the token for audiences other than the
+		// configured ones, or does not check audiences at all
+		params.log.Info("token is not valid for the configured audiences, rotating", "audiences", params.audiences,
+			"reviewedAudiences", tokenReview.Status.Audiences)
 		return true, nil
 	}
-	parts := strings.Split(currentToken, ".")
-	encodedPayload := parts[1]
+	return age+r.ClockSkewTolerance > renewalAge, nil
+}
 
-	decodedPayload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
-	if err != nil {
-		return false, fmt.Errorf("failed to decode payload: %w", err)
+func (r *SecretReconciler) namespaceExists(ctx context.Context, c client.Client, name string) (bool, error) {
+	ctx, cancel := r.withClientTimeout(ctx)
+	defer cancel()
+	err := c.Get(ctx, client.ObjectKey{Name: name}, &corev1.Namespace{})
+	switch {
+	case apierrors.IsNotFound(err):
+		return false, nil
+	case apierrors.IsForbidden(err):
+		return false, fmt.Errorf("not allowed to get namespace %s in the metal cluster, grant get on namespaces or set restrictToServiceAccountNamespace: %w", name, err)
 	}
+	return err == nil, err
+}
 
-	var claims jwtClaims
-	err = json.Unmarshal(decodedPayload, &claims)
+// makeTargetClient builds a client for the named context of the kubeconfig in
+// secret, or for its current-context if contextName is empty.
+func makeTargetClient(secret *corev1.Secret, contextName string, scheme *runtime.Scheme) (client.Client, *rest.Config, error) {
+	// broken target secrets are retried with backoff, since target secrets
+	// are not watched in all setups and a fix could go unnoticed otherwise
+	configData, ok := secret.Data["kubeconfig"]
+	if !ok {
+		return nil, nil, &invalidKubeconfigError{err: errors.New("did not find kubeconfig key in secret")}
+	}
+	kubeconfig, err := clientcmd.Load(configData)
+	if err != nil {
+		return nil, nil, &invalidKubeconfigError{err: err}
+	}
+	if err := validateKubeconfig(kubeconfig, contextName); err != nil {
+		return nil, nil, &invalidKubeconfigError{err: err}
+	}
+	if err := inlineCertificateAuthority(kubeconfig, contextName, secret.Data); err != nil {
+		return nil, nil, &invalidKubeconfigError{err: err}
+	}
+	config, err := clientcmd.NewDefaultClientConfig(*kubeconfig, &clientcmd.ConfigOverrides{CurrentContext: contextName}).ClientConfig()
+	if err != nil {
+		return nil, nil, &invalidKubeconfigError{err: err}
+	}
+	targetClient, err := client.New(config, client.Options{Scheme: scheme})
 	if err != nil {
-		return false, fmt.Errorf("failed to unmarshal claims: %w", err)
+		return nil, nil, err
 	}
+	return targetClient, config, nil
+}
 
-	iatTime := time.Unix(claims.Iat, 0)
-	expTime := time.Unix(claims.Exp, 0)
-	age := Now().Sub(iatTime)
-	lifetime := expTime.Sub(iatTime)
-	log.Info("token info", "age seconds", age.Seconds(), "lifetime seconds", lifetime.Seconds())
-	return age > lifetime/2, nil
+func (r *SecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Recorder == nil {
+		r.Recorder = mgr.GetEventRecorderFor("metal-token-rotate")
+	}
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Secret{}, builder.WithPredicates(predicate.NewPredicateFuncs(r.isManagedSecret))).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.MaxConcurrentReconciles,
+			RateLimiter:             r.rateLimiter(),
+			NewQueue:                newQueue,
+		})
+	if r.LocalCluster != nil {
+		b = b.WatchesRawSource(source.Kind(r.LocalCluster.GetCache(), &corev1.Secret{},
+			handler.TypedEnqueueRequestsFromMapFunc(r.requestsForTargetSecret)))
+	}
+	if r.ResyncOnConfigChange {
+		resync := newConfigResync(r)
+		if err := mgr.Add(resync); err != nil {
+			return err
+		}
+		b = b.WatchesRawSource(resync.source())
+	}
+	return b.Complete(r)
 }
 
-func makeTargetClient(ctx context.Context, cl client.Client, targetSecret types.NamespacedName) (client.Client, error) {
-	var secret corev1.Secret
-	err := cl.Get(ctx, targetSecret, &secret)
-	if err != nil {
-		return nil, err
+// requestsForTargetSecret drops the cached client for a changed target secret
+// and enqueues all managed secrets of the identities using it.
+func (r *SecretReconciler) requestsForTargetSecret(ctx context.Context, targetSecret *corev1.Secret) []reconcile.Request {
+	key := client.ObjectKeyFromObject(targetSecret)
+	identities := make(map[string]bool)
+	for _, c := range r.ConfigWatcher.Config().Clusters {
+		if c.TargetSecretName == key.Name && c.TargetSecretNamespace == key.Namespace {
+			identities[c.Identity] = true
+		}
 	}
-	configData, ok := secret.Data["kubeconfig"]
-	if !ok {
-		return nil, errors.New("did not find kubeconfig key in secret")
+	if len(identities) == 0 {
+		return nil
 	}
-	config, err := clientcmd.RESTConfigFromKubeConfig(configData)
+	r.targetClients.invalidate(key)
+
+	requests, err := r.annotatedSecretRequests(ctx, func(t target) bool { return identities[t.identity] })
 	if err != nil {
+		r.Log.Error(err, "unable to list secrets for changed target secret", "targetSecret", key)
+		return nil
+	}
+	return requests
+}
+
+// annotatedSecretRequests returns requests for all secrets whose
+// autoprovision annotation is valid and matches.
+func (r *SecretReconciler) annotatedSecretRequests(ctx context.Context, matches func(target) bool, opts ...client.ListOption) ([]reconcile.Request, error) {
+	var secrets corev1.SecretList
+	if err := r.GardenClient.List(ctx, &secrets, opts...); err != nil {
 		return nil, err
 	}
-	return client.New(config, client.Options{Scheme: cl.Scheme()})
+	var requests []reconcile.Request
+	key := r.AnnotationKeys.orDefault().Autoprovision
+	for _, secret := range secrets.Items {
+		value, ok := secret.Annotations[key]
+		if !ok {
+			continue
+		}
+		if target, err := parseAutoprovisionValue(value); err == nil && matches(target) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&secret)})
+		}
+	}
+	return requests, nil
 }
 
-func (r *SecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&corev1.Secret{}).
-		Complete(r)
+// newQueue builds the same queue as controller-runtime does by default, but
+// exposes its length as metal_token_reconcile_queue_depth.
+func newQueue(controllerName string, rateLimiter workqueue.TypedRateLimiter[reconcile.Request]) workqueue.TypedRateLimitingInterface[reconcile.Request] {
+	queue := workqueue.NewTypedRateLimitingQueueWithConfig(rateLimiter, workqueue.TypedRateLimitingQueueConfig[reconcile.Request]{
+		Name: controllerName,
+	})
+	queueLen := queue.Len
+	reconcileQueueLen.Store(&queueLen)
+	return queue
+}
+
+func (r *SecretReconciler) rateLimiter() workqueue.TypedRateLimiter[reconcile.Request] {
+	if r.RetryBaseDelay <= 0 || r.RetryMaxDelay <= 0 {
+		return nil
+	}
+	return workqueue.NewTypedItemExponentialFailureRateLimiter[reconcile.Request](r.RetryBaseDelay, r.RetryMaxDelay)
+}
+
+// isManagedSecret also matches secrets that lost the annotation but still
+// carry the finalizer, so that the finalizer is removed from them.
+func (r *SecretReconciler) isManagedSecret(obj client.Object) bool {
+	_, ok := obj.GetAnnotations()[r.AnnotationKeys.orDefault().Autoprovision]
+	return ok || controllerutil.ContainsFinalizer(obj, TokenRevocationFinalizer)
 }
@@ -4,35 +4,72 @@
 package controllers_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
 	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gstruct"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
+	authenticationv1 "k8s.io/api/authentication/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 
 	"github.com/ironcore-dev/metal-token-rotate/controllers"
 )
 
-var _ = Describe("The secret controller", func() {
+// eventReasons returns the reasons of all events for secret.
+func eventReasons(ctx context.Context, secret *corev1.Secret) []string {
+	var events corev1.EventList
+	Expect(gardenClient.List(ctx, &events, client.InNamespace(secret.Namespace))).To(Succeed())
+	var reasons []string
+	for _, event := range events.Items {
+		if event.InvolvedObject.Name == secret.Name {
+			reasons = append(reasons, event.Reason)
+		}
+	}
+	return reasons
+}
+
+var _ = Describe("The secret controller", Label("envtest"), func() {
 
 	var secret *corev1.Secret
 
+	BeforeEach(skipWithoutEnvtest)
+
 	BeforeEach(func() {
 		controllers.Now = time.Now
 		secret = &corev1.Secret{}
 		secret.Namespace = metav1.NamespaceDefault
-	})
-
-	AfterEach(func(ctx SpecContext) {
-		Expect(client.IgnoreNotFound(gardenClient.Delete(ctx, secret))).To(Succeed())
+		// registered here, so that it does not run for skipped specs
+		DeferCleanup(func(ctx SpecContext) {
+			Expect(client.IgnoreNotFound(gardenClient.Delete(ctx, secret))).To(Succeed())
+		})
 	})
 
 	It("injects a token into an autoprovisioned secret", func(ctx SpecContext) {
 		secret.Name = "test-secret-inject"
-		secret.Annotations = map[string]string{controllers.AutoprovisonAnnotationKey: identity + "/server-namespace"}
+		secret.Annotations = map[string]string{controllers.AutoprovisionAnnotationKey: identity + "/server-namespace"}
 		Expect(gardenClient.Create(ctx, secret)).To(Succeed())
 
 		Eventually(func() map[string][]byte {
@@ -46,9 +83,77 @@ var _ = Describe("The secret controller", func() {
 		))
 	})
 
+	It("labels the secret as managed", func(ctx SpecContext) {
+		secret.Name = "test-secret-label"
+		secret.Annotations = map[string]string{controllers.AutoprovisionAnnotationKey: identity + "/server-namespace"}
+		Expect(gardenClient.Create(ctx, secret)).To(Succeed())
+
+		Eventually(func() map[string]string {
+			var result corev1.Secret
+			Expect(gardenClient.Get(ctx, client.ObjectKeyFromObject(secret), &result)).To(Succeed())
+			return result.Labels
+		}).Should(HaveKeyWithValue(controllers.ManagedByLabelKey, controllers.ManagedByLabelValue))
+	})
+
+	It("annotates the secret with the token expiry", func(ctx SpecContext) {
+		secret.Name = "test-secret-expiry"
+		secret.Annotations = map[string]string{controllers.AutoprovisionAnnotationKey: identity + "/server-namespace"}
+		Expect(gardenClient.Create(ctx, secret)).To(Succeed())
+
+		Eventually(func() map[string]string {
+			var result corev1.Secret
+			Expect(gardenClient.Get(ctx, client.ObjectKeyFromObject(secret), &result)).To(Succeed())
+			return result.Annotations
+		}).Should(SatisfyAll(
+			HaveKey(controllers.TokenIssuedAtAnnotationKey),
+			HaveKey(controllers.TokenExpiresAtAnnotationKey),
+		))
+	})
+
+	It("writes a kubeconfig when configured", func(ctx SpecContext) {
+		secret.Name = "test-secret-kubeconfig"
+		secret.Annotations = map[string]string{controllers.AutoprovisionAnnotationKey: kubeconfigIdentity + "/server-namespace"}
+		Expect(gardenClient.Create(ctx, secret)).To(Succeed())
+
+		var result corev1.Secret
+		Eventually(func() map[string][]byte {
+			Expect(gardenClient.Get(ctx, client.ObjectKeyFromObject(secret), &result)).To(Succeed())
+			return result.Data
+		}).Should(HaveKey("kubeconfig"))
+
+		kubeconfig, err := clientcmd.Load(result.Data["kubeconfig"])
+		Expect(err).ToNot(HaveOccurred())
+		kubeContext := kubeconfig.Contexts[kubeconfig.CurrentContext]
+		Expect(kubeContext).ToNot(BeNil())
+		Expect(kubeContext.Namespace).To(Equal("server-namespace"))
+		Expect(kubeconfig.AuthInfos[kubeContext.AuthInfo].Token).To(BeEquivalentTo(result.Data["token"]))
+		Expect(kubeconfig.Clusters[kubeContext.Cluster].CertificateAuthorityData).ToNot(BeEmpty())
+	})
+
+	It("injects additional tokens into their own keys", func(ctx SpecContext) {
+		secret.Name = "test-secret-multi-token"
+		secret.Annotations = map[string]string{controllers.AutoprovisionAnnotationKey: multiTokenIdentity + "/server-namespace"}
+		Expect(gardenClient.Create(ctx, secret)).To(Succeed())
+
+		var result corev1.Secret
+		Eventually(func() map[string][]byte {
+			Expect(gardenClient.Get(ctx, client.ObjectKeyFromObject(secret), &result)).To(Succeed())
+			return result.Data
+		}).Should(SatisfyAll(HaveKey("token"), HaveKey("secondToken")))
+		Expect(result.Data["secondToken"]).ToNot(Equal(result.Data["token"]))
+	})
+
+	It("emits an event when a token is issued", func(ctx SpecContext) {
+		secret.Name = "test-secret-event"
+		secret.Annotations = map[string]string{controllers.AutoprovisionAnnotationKey: identity + "/server-namespace"}
+		Expect(gardenClient.Create(ctx, secret)).To(Succeed())
+
+		Eventually(eventReasons).WithArguments(ctx, secret).Should(ContainElement(controllers.EventReasonTokenIssued))
+	})
+
 	It("rotates the token in an autoprovisioned secret", func(ctx SpecContext) {
 		secret.Name = "test-secret-rotate"
-		secret.Annotations = map[string]string{controllers.AutoprovisonAnnotationKey: identity + "/server-namespace"}
+		secret.Annotations = map[string]string{controllers.AutoprovisionAnnotationKey: identity + "/server-namespace"}
 		Expect(gardenClient.Create(ctx, secret)).To(Succeed())
 
 		controllers.Now = func() time.Time {
@@ -63,7 +168,7 @@ var _ = Describe("The secret controller", func() {
 			return oldToken
 		}).ShouldNot(BeNil())
 
-		// force reconciliation
+		// force reconciliation, this clears all labels and the managed-by label is set again
 		unmodifiedSecret := secret.DeepCopy()
 		unmodifiedSecret.Labels = map[string]string{"a": "b"}
 		Expect(gardenClient.Patch(ctx, secret, client.MergeFrom(unmodifiedSecret))).To(Succeed())
@@ -75,6 +180,46 @@ var _ = Describe("The secret controller", func() {
 		}).ShouldNot(Equal(oldToken))
 	})
 
+	It("removes its finalizer when a managed secret is deleted", func(ctx SpecContext) {
+		secret.Name = "test-secret-finalizer"
+		secret.Annotations = map[string]string{controllers.AutoprovisionAnnotationKey: identity + "/server-namespace"}
+		Expect(gardenClient.Create(ctx, secret)).To(Succeed())
+
+		Eventually(func() []string {
+			var result corev1.Secret
+			Expect(gardenClient.Get(ctx, client.ObjectKeyFromObject(secret), &result)).To(Succeed())
+			return result.Finalizers
+		}).Should(ContainElement(controllers.TokenRevocationFinalizer))
+
+		Expect(gardenClient.Delete(ctx, secret)).To(Succeed())
+		Eventually(func() error {
+			return gardenClient.Get(ctx, client.ObjectKeyFromObject(secret), &corev1.Secret{})
+		}).Should(Satisfy(apierrors.IsNotFound))
+	})
+
+	It("injects a token if the verified target namespace exists", func(ctx SpecContext) {
+		secret.Name = "test-secret-verified-namespace"
+		secret.Annotations = map[string]string{controllers.AutoprovisionAnnotationKey: verifyNsIdentity + "/" + metav1.NamespaceDefault}
+		Expect(gardenClient.Create(ctx, secret)).To(Succeed())
+
+		Eventually(func() map[string][]byte {
+			var result corev1.Secret
+			Expect(gardenClient.Get(ctx, client.ObjectKeyFromObject(secret), &result)).To(Succeed())
+			return result.Data
+		}).Should(HaveKey("token"))
+	})
+
+	It("does not inject a token if the verified target namespace is missing", func(ctx SpecContext) {
+		secret.Name = "test-secret-missing-namespace"
+		secret.Annotations = map[string]string{controllers.AutoprovisionAnnotationKey: verifyNsIdentity + "/missing-namespace"}
+		Expect(gardenClient.Create(ctx, secret)).To(Succeed())
+
+		Eventually(eventReasons).WithArguments(ctx, secret).Should(ContainElement(controllers.EventReasonTargetNamespaceNotFound))
+		var result corev1.Secret
+		Expect(gardenClient.Get(ctx, client.ObjectKeyFromObject(secret), &result)).To(Succeed())
+		Expect(result.Data).ToNot(HaveKey("token"))
+	})
+
 	It("does not inject a token into a secret without the autoprovision annotation", func(ctx SpecContext) {
 		secret.Name = "test-secret-no-annotation"
 		Expect(gardenClient.Create(ctx, secret)).To(Succeed())
@@ -86,9 +231,24 @@ var _ = Describe("The secret controller", func() {
 		}).Should(BeEmpty())
 	})
 
+	It("injects a token once the autoprovision annotation is added", func(ctx SpecContext) {
+		secret.Name = "test-secret-annotation-added"
+		Expect(gardenClient.Create(ctx, secret)).To(Succeed())
+
+		unmodifiedSecret := secret.DeepCopy()
+		secret.Annotations = map[string]string{controllers.AutoprovisionAnnotationKey: identity + "/server-namespace"}
+		Expect(gardenClient.Patch(ctx, secret, client.MergeFrom(unmodifiedSecret))).To(Succeed())
+
+		Eventually(func() map[string][]byte {
+			var result corev1.Secret
+			Expect(gardenClient.Get(ctx, client.ObjectKeyFromObject(secret), &result)).To(Succeed())
+			return result.Data
+		}).Should(HaveKey("token"))
+	})
+
 	It("does not inject a token into a secret with an invalid autoprovision annotation", func(ctx SpecContext) {
 		secret.Name = "test-secret-invalid-annotation"
-		secret.Annotations = map[string]string{controllers.AutoprovisonAnnotationKey: "invalid"}
+		secret.Annotations = map[string]string{controllers.AutoprovisionAnnotationKey: "invalid"}
 		Expect(gardenClient.Create(ctx, secret)).To(Succeed())
 
 		Consistently(func() map[string][]byte {
@@ -99,3 +259,901 @@ var _ = Describe("The secret controller", func() {
 	})
 
 })
+
+var _ = DescribeTable("ParseAutoprovisionValue",
+	func(value, expectedIdentity, expectedNamespace, expectedErr string) {
+		identity, namespace, err := controllers.ParseAutoprovisionValue(value)
+		if expectedErr != "" {
+			Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+			return
+		}
+		Expect(err).ToNot(HaveOccurred())
+		Expect(identity).To(Equal(expectedIdentity))
+		Expect(namespace).To(Equal(expectedNamespace))
+	},
+	Entry("accepts identity and namespace", "cluster-a.example.com/metal", "cluster-a.example.com", "metal", ""),
+	Entry("rejects values without a slash", "cluster-a", "", "", "expected <identity>/<namespace>"),
+	Entry("rejects extra parts", "cluster-a/ns/extra", "", "", "expected exactly one slash"),
+	Entry("rejects an empty identity", "/ns", "", "", "identity is empty"),
+	Entry("rejects an empty namespace", "cluster-a/", "", "", "namespace is empty"),
+	Entry("rejects an invalid identity", "Cluster_A/ns", "", "", "invalid identity"),
+	Entry("rejects an invalid namespace", "cluster-a/my.namespace", "", "", `invalid namespace "my.namespace"`),
+	Entry("accepts several namespaces", "cluster-a/ns1,ns2,{{ .SecretNamespace }}", "cluster-a", "ns1,ns2,{{ .SecretNamespace }}", ""),
+	Entry("rejects an empty namespace in a list", "cluster-a/ns1,,ns2", "", "", "namespace is empty"),
+	Entry("rejects a trailing comma", "cluster-a/ns1,", "", "", "namespace is empty"),
+	Entry("rejects duplicate namespaces", "cluster-a/ns1,ns1", "", "", `namespace "ns1" is listed twice`),
+	Entry("rejects range actions", "cluster-a/x{{range 1000000000000}}a{{end}}", "", "", "unsupported template action {{range"),
+	Entry("rejects function calls other than label", `cluster-a/{{ printf "%0100d" 0 }}`, "", "", "unsupported template action"),
+	Entry("rejects pipelines", `cluster-a/{{ .SecretName | printf "%s" }}`, "", "", "unsupported template action"),
+	Entry("rejects variables", "cluster-a/{{ $x := .SecretName }}", "", "", "unsupported template action"),
+	Entry("rejects nested fields", "cluster-a/{{ .SecretName.Length }}", "", "", "unsupported template action"),
+	Entry("rejects label calls with other arguments", "cluster-a/{{ label .SecretName }}", "", "", "unsupported template action"),
+	Entry("rejects template definitions", `cluster-a/{{ define "x" }}a{{ end }}ns`, "", "", "template definitions are not supported"),
+)
+
+var _ = DescribeTable("ResolveTargetNamespace",
+	func(value, expectedNamespace, expectedErr string) {
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Name:      "metal-token",
+			Namespace: "shoot--team",
+			Labels:    map[string]string{"team": "blue"},
+		}}
+		namespace, err := controllers.ResolveTargetNamespace(value, secret)
+		if expectedErr != "" {
+			Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+			return
+		}
+		Expect(err).ToNot(HaveOccurred())
+		Expect(namespace).To(Equal(expectedNamespace))
+	},
+	Entry("keeps plain namespaces", "cluster-a/metal", "metal", ""),
+	Entry("renders the secret namespace", "cluster-a/{{ .SecretNamespace }}", "shoot--team", ""),
+	Entry("renders labels", `cluster-a/metal-{{ label "team" }}`, "metal-blue", ""),
+	Entry("rejects unknown variables", "cluster-a/{{ .Namespace }}", "", "unsupported template action {{.Namespace}}"),
+	Entry("rejects rendered namespaces longer than a namespace name", "cluster-a/{{ .SecretName }}{{ .SecretName }}{{ .SecretName }}{{ .SecretName }}{{ .SecretName }}{{ .SecretName }}", "", "rendered template is longer than 63 bytes"),
+	Entry("rejects missing labels", `cluster-a/{{ label "owner" }}ns`, "", `secret has no label "owner"`),
+	Entry("rejects invalid templates", "cluster-a/{{ .SecretNamespace", "", "invalid namespace template"),
+	Entry("rejects rendered namespaces that are invalid", "cluster-a/{{ .SecretName }}.x", "", `invalid namespace "metal-token.x"`),
+	Entry("renders each namespace", "cluster-a/metal,{{ .SecretNamespace }}", "metal,shoot--team", ""),
+	Entry("rejects namespaces rendered twice", "cluster-a/shoot--team,{{ .SecretNamespace }}", "", `namespace "shoot--team" is listed twice`),
+)
+
+// recordingTokenSink records stored tokens and fails with err if set.
+type recordingTokenSink struct {
+	tokens []controllers.IssuedToken
+	err    error
+}
+
+func (s *recordingTokenSink) StoreToken(_ context.Context, _ *corev1.Secret, token controllers.IssuedToken) error {
+	s.tokens = append(s.tokens, token)
+	return s.err
+}
+
+var _ = Describe("RotateNow", func() {
+
+	var (
+		r          *controllers.SecretReconciler
+		gardenFake client.Client
+		secretKey  client.ObjectKey
+	)
+
+	BeforeEach(func() {
+		path := filepath.Join(GinkgoT().TempDir(), "config.json")
+		Expect(os.WriteFile(path, []byte(`{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"}]}`), 0644)).To(Succeed())
+		configWatcher, err := controllers.NewConfigWatcher(path, GinkgoLogr)
+		Expect(err).ToNot(HaveOccurred())
+
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "metal-token",
+				Namespace:   "garden",
+				Annotations: map[string]string{controllers.AutoprovisionAnnotationKey: "cluster-a/metal"},
+			},
+			Data: map[string][]byte{"token": []byte("leaked-token")},
+		}
+		secretKey = client.ObjectKeyFromObject(secret)
+		gardenFake = fake.NewClientBuilder().WithObjects(secret).Build()
+		serviceAccount := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "sa", Namespace: "ns"}}
+		r = &controllers.SecretReconciler{
+			GardenClient:  gardenFake,
+			LocalClient:   fake.NewClientBuilder().WithObjects(serviceAccount).Build(),
+			Log:           GinkgoLogr,
+			ConfigWatcher: configWatcher,
+			Recorder:      &record.FakeRecorder{},
+		}
+	})
+
+	It("replaces a token that is still valid", func(ctx SpecContext) {
+		now := time.Now()
+		validToken := fakeToken(now, now.Add(24*time.Hour))
+		var secret corev1.Secret
+		Expect(gardenFake.Get(ctx, secretKey, &secret)).To(Succeed())
+		secret.Data["token"] = []byte(validToken)
+		Expect(gardenFake.Update(ctx, &secret)).To(Succeed())
+
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: secretKey})
+		Expect(err).ToNot(HaveOccurred())
+		var result corev1.Secret
+		Expect(gardenFake.Get(ctx, secretKey, &result)).To(Succeed())
+		Expect(result.Data).To(HaveKeyWithValue("token", BeEquivalentTo(validToken)))
+
+		Expect(r.RotateNow(ctx, secretKey)).To(Succeed())
+		Expect(gardenFake.Get(ctx, secretKey, &result)).To(Succeed())
+		Expect(result.Data).To(HaveKeyWithValue("token", BeEquivalentTo("fake-token")))
+	})
+
+	It("warns about tokens that are shorter than requested", func(ctx SpecContext) {
+		recorder := record.NewFakeRecorder(10)
+		r.Recorder = recorder
+		r.LocalClient = fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+			SubResourceCreate: func(ctx context.Context, c client.Client, subResourceName string, obj, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+				now := time.Now()
+				subResource.(*authenticationv1.TokenRequest).Status.Token = fakeToken(now, now.Add(10*time.Minute))
+				return nil
+			},
+		}).Build()
+		Expect(r.RotateNow(ctx, secretKey)).To(Succeed())
+		Expect(recorder.Events).To(Receive(HavePrefix("Warning " + controllers.EventReasonTokenLifetimeMismatch + " token for identity cluster-a is valid for 10m0s instead of the requested 1h0m0s")))
+	})
+
+	It("records issued tokens in the audit sink", func(ctx SpecContext) {
+		var audit bytes.Buffer
+		r.AuditSink = controllers.NewJSONLinesAuditSink(&audit)
+		iat := time.Unix(1700000000, 0)
+		token := fakeToken(iat, iat.Add(time.Hour))
+		r.LocalClient = fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+			SubResourceCreate: func(ctx context.Context, c client.Client, subResourceName string, obj, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+				tokenRequest := subResource.(*authenticationv1.TokenRequest)
+				tokenRequest.Spec.Audiences = []string{"https://metal.example.com"}
+				tokenRequest.Status.Token = token
+				return nil
+			},
+		}).Build()
+		Expect(r.RotateNow(ctx, secretKey)).To(Succeed())
+
+		Expect(audit.String()).ToNot(ContainSubstring(token))
+		var record controllers.AuditRecord
+		Expect(json.Unmarshal(audit.Bytes(), &record)).To(Succeed())
+		Expect(record).To(MatchFields(IgnoreExtras, Fields{
+			"SecretNamespace":         Equal("garden"),
+			"SecretName":              Equal("metal-token"),
+			"TokenKey":                Equal("token"),
+			"Identity":                Equal("cluster-a"),
+			"ServiceAccountNamespace": Equal("ns"),
+			"ServiceAccountName":      Equal("sa"),
+			"Audiences":               ConsistOf("https://metal.example.com"),
+			"IssuedAt":                BeTemporally("==", iat),
+			"ExpiresAt":               BeTemporally("==", iat.Add(time.Hour)),
+		}))
+	})
+
+	It("limits token requests per identity", func(ctx SpecContext) {
+		path := filepath.Join(GinkgoT().TempDir(), "config.json")
+		Expect(os.WriteFile(path, []byte(`{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a","maxTokensPerMinute":1}]}`), 0644)).To(Succeed())
+		configWatcher, err := controllers.NewConfigWatcher(path, GinkgoLogr)
+		Expect(err).ToNot(HaveOccurred())
+		recorder := record.NewFakeRecorder(10)
+		r.ConfigWatcher = configWatcher
+		r.Recorder = recorder
+
+		Expect(r.RotateNow(ctx, secretKey)).To(Succeed())
+		Expect(r.RotateNow(ctx, secretKey)).To(MatchError(ContainSubstring("was not rotated: RateLimited")))
+		Expect(recorder.Events).To(Receive(HavePrefix("Normal TokenRotated")))
+		Expect(recorder.Events).To(Receive(HavePrefix("Warning " + controllers.EventReasonTokenIssuanceRateLimited + " token issuance for identity cluster-a is rate limited")))
+		var result corev1.Secret
+		Expect(gardenFake.Get(ctx, secretKey, &result)).To(Succeed())
+		Expect(result.Data).To(HaveKeyWithValue("token", BeEquivalentTo("fake-token")))
+	})
+
+	It("gives up on hanging token requests with a retryable error", func(ctx SpecContext) {
+		r.ClientTimeout = 10 * time.Millisecond
+		r.LocalClient = fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+			SubResourceCreate: func(ctx context.Context, c client.Client, subResourceName string, obj, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		}).Build()
+		err := r.RotateNow(ctx, secretKey)
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+		Expect(errors.Is(err, reconcile.TerminalError(nil))).To(BeFalse())
+	})
+
+	DescribeTable("only requests tokens for allowed service accounts",
+		func(ctx SpecContext, patterns string, allowed bool) {
+			path := filepath.Join(GinkgoT().TempDir(), "config.json")
+			Expect(os.WriteFile(path, []byte(`{"allowedServiceAccounts":`+patterns+`,"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"}]}`), 0644)).To(Succeed())
+			configWatcher, err := controllers.NewConfigWatcher(path, GinkgoLogr)
+			Expect(err).ToNot(HaveOccurred())
+			recorder := record.NewFakeRecorder(10)
+			r.ConfigWatcher = configWatcher
+			r.Recorder = recorder
+
+			err = r.RotateNow(ctx, secretKey)
+			if allowed {
+				Expect(err).ToNot(HaveOccurred())
+				return
+			}
+			Expect(err).To(MatchError(ContainSubstring("service account ns/sa is not in allowedServiceAccounts")))
+			Expect(errors.Is(err, reconcile.TerminalError(nil))).To(BeTrue())
+			Expect(recorder.Events).To(Receive(HavePrefix("Warning " + controllers.EventReasonServiceAccountNotAllowed)))
+		},
+		Entry("allows matching names", `["other/sa","ns/sa"]`, true),
+		Entry("allows matching patterns", `["n*/*"]`, true),
+		Entry("refuses other service accounts", `["ns/sa-*","other/*"]`, false),
+	)
+
+	It("backs off from tokens whose service account is missing", func(ctx SpecContext) {
+		recorder := record.NewFakeRecorder(10)
+		r.Recorder = recorder
+		r.LocalClient = fake.NewClientBuilder().Build()
+
+		result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: secretKey})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(Equal(ctrl.Result{RequeueAfter: 15 * time.Minute}))
+		Expect(recorder.Events).To(Receive(Equal("Warning " + controllers.EventReasonServiceAccountNotFound +
+			" cannot request a token for identity cluster-a: service account ns/sa does not exist in the metal cluster")))
+		var secret corev1.Secret
+		Expect(gardenFake.Get(ctx, secretKey, &secret)).To(Succeed())
+		Expect(secret.Data).To(HaveKeyWithValue("token", BeEquivalentTo("leaked-token")))
+	})
+
+	It("creates missing service accounts if configured", func(ctx SpecContext) {
+		path := filepath.Join(GinkgoT().TempDir(), "config.json")
+		Expect(os.WriteFile(path, []byte(`{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a","createServiceAccountIfMissing":true}]}`), 0644)).To(Succeed())
+		configWatcher, err := controllers.NewConfigWatcher(path, GinkgoLogr)
+		Expect(err).ToNot(HaveOccurred())
+		recorder := record.NewFakeRecorder(10)
+		r.ConfigWatcher = configWatcher
+		r.Recorder = recorder
+		r.LocalClient = fake.NewClientBuilder().Build()
+
+		Expect(r.RotateNow(ctx, secretKey)).To(Succeed())
+		Expect(recorder.Events).To(Receive(HavePrefix("Normal " + controllers.EventReasonServiceAccountCreated + " created missing service account ns/sa")))
+		var serviceAccount corev1.ServiceAccount
+		Expect(r.LocalClient.Get(ctx, client.ObjectKey{Namespace: "ns", Name: "sa"}, &serviceAccount)).To(Succeed())
+		Expect(serviceAccount.Labels).To(HaveKeyWithValue(controllers.ManagedByLabelKey, controllers.ManagedByLabelValue))
+		var secret corev1.Secret
+		Expect(gardenFake.Get(ctx, secretKey, &secret)).To(Succeed())
+		Expect(secret.Data).To(HaveKeyWithValue("token", BeEquivalentTo("fake-token")))
+	})
+
+	It("retries quickly after conflicting patches without counting an error", func(ctx SpecContext) {
+		r.GardenClient = interceptor.NewClient(gardenFake.(client.WithWatch), interceptor.Funcs{
+			Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+				return apierrors.NewConflict(corev1.Resource("secrets"), obj.GetName(), errors.New("the object has been modified"))
+			},
+		})
+		errorsBefore := testutil.ToFloat64(controllers.TokenRotationsTotal.WithLabelValues("cluster-a", "error"))
+
+		result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: secretKey})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(Equal(ctrl.Result{RequeueAfter: time.Second}))
+		Expect(testutil.ToFloat64(controllers.TokenRotationsTotal.WithLabelValues("cluster-a", "error"))).To(Equal(errorsBefore))
+	})
+
+	Context("with token sinks", func() {
+
+		BeforeEach(func() {
+			path := filepath.Join(GinkgoT().TempDir(), "config.json")
+			Expect(os.WriteFile(path, []byte(`{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a","tokenSinks":["vault"]}]}`), 0644)).To(Succeed())
+			configWatcher, err := controllers.NewConfigWatcher(path, GinkgoLogr)
+			Expect(err).ToNot(HaveOccurred())
+			r.ConfigWatcher = configWatcher
+		})
+
+		It("stores issued tokens in the secret and the configured sinks", func(ctx SpecContext) {
+			sink := &recordingTokenSink{}
+			r.TokenSinks = map[string]controllers.TokenSink{"vault": sink}
+
+			Expect(r.RotateNow(ctx, secretKey)).To(Succeed())
+			Expect(sink.tokens).To(ConsistOf(controllers.IssuedToken{
+				Key:            "token",
+				Token:          "fake-token",
+				Identity:       "cluster-a",
+				ServiceAccount: types.NamespacedName{Namespace: "ns", Name: "sa"},
+			}))
+			var result corev1.Secret
+			Expect(gardenFake.Get(ctx, secretKey, &result)).To(Succeed())
+			Expect(result.Data).To(HaveKeyWithValue("token", BeEquivalentTo("fake-token")))
+		})
+
+		It("keeps the current token if a sink fails", func(ctx SpecContext) {
+			r.TokenSinks = map[string]controllers.TokenSink{"vault": &recordingTokenSink{err: errors.New("sealed")}}
+
+			Expect(r.RotateNow(ctx, secretKey)).To(MatchError(`failed to store token in sink "vault": sealed`))
+			var result corev1.Secret
+			Expect(gardenFake.Get(ctx, secretKey, &result)).To(Succeed())
+			Expect(result.Data).To(HaveKeyWithValue("token", BeEquivalentTo("leaked-token")))
+		})
+
+		It("fails for sinks that are not registered", func(ctx SpecContext) {
+			Expect(r.RotateNow(ctx, secretKey)).To(MatchError(`unknown token sink "vault"`))
+		})
+
+	})
+
+	Context("with credentials restricted to a namespace", func() {
+
+		BeforeEach(func() {
+			serviceAccount := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "sa", Namespace: "metal"}}
+			// namespaced clients look up the scope of objects
+			mapper := meta.NewDefaultRESTMapper(nil)
+			mapper.Add(corev1.SchemeGroupVersion.WithKind("ServiceAccount"), meta.RESTScopeNamespace)
+			r.LocalClient = fake.NewClientBuilder().WithRESTMapper(mapper).WithObjects(serviceAccount).WithInterceptorFuncs(interceptor.Funcs{
+				Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+					if _, ok := obj.(*corev1.Namespace); ok {
+						return apierrors.NewForbidden(corev1.Resource("namespaces"), key.Name, errors.New("access denied"))
+					}
+					return c.Get(ctx, key, obj, opts...)
+				},
+			}).Build()
+		})
+
+		useConfig := func(cluster string) {
+			path := filepath.Join(GinkgoT().TempDir(), "config.json")
+			Expect(os.WriteFile(path, []byte(`{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"{{ .TargetNamespace }}","identity":"cluster-a"`+cluster+`}]}`), 0644)).To(Succeed())
+			configWatcher, err := controllers.NewConfigWatcher(path, GinkgoLogr)
+			Expect(err).ToNot(HaveOccurred())
+			r.ConfigWatcher = configWatcher
+		}
+
+		It("explains which permission is missing", func(ctx SpecContext) {
+			useConfig("")
+			Expect(r.RotateNow(ctx, secretKey)).To(MatchError(ContainSubstring("not allowed to get namespace metal in the metal cluster, grant get on namespaces or set restrictToServiceAccountNamespace")))
+		})
+
+		It("only makes requests in the service account namespace", func(ctx SpecContext) {
+			useConfig(`,"restrictToServiceAccountNamespace":true`)
+			Expect(r.RotateNow(ctx, secretKey)).To(Succeed())
+		})
+
+		It("rejects annotations whose tokens come from several namespaces", func(ctx SpecContext) {
+			useConfig(`,"restrictToServiceAccountNamespace":true`)
+			var secret corev1.Secret
+			Expect(gardenFake.Get(ctx, secretKey, &secret)).To(Succeed())
+			secret.Annotations[controllers.AutoprovisionAnnotationKey] = "cluster-a/metal,other"
+			Expect(gardenFake.Update(ctx, &secret)).To(Succeed())
+
+			err := r.RotateNow(ctx, secretKey)
+			Expect(err).To(MatchError(ContainSubstring("but key token.other uses other instead of metal")))
+			Expect(errors.Is(err, reconcile.TerminalError(nil))).To(BeTrue())
+		})
+
+	})
+
+	It("explains forbidden token requests", func(ctx SpecContext) {
+		r.LocalClient = fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+			SubResourceCreate: func(ctx context.Context, c client.Client, subResourceName string, obj, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+				return apierrors.NewForbidden(corev1.Resource("serviceaccounts/token"), obj.GetName(), errors.New("access denied"))
+			},
+		}).Build()
+		Expect(r.RotateNow(ctx, secretKey)).To(MatchError(ContainSubstring("not allowed to request tokens for service account ns/sa, grant create on serviceaccounts/token in namespace ns of the metal cluster")))
+	})
+
+	Context("with emitCACert", func() {
+
+		BeforeEach(func() {
+			path := filepath.Join(GinkgoT().TempDir(), "config.json")
+			Expect(os.WriteFile(path, []byte(`{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a","emitCACert":true}]}`), 0644)).To(Succeed())
+			configWatcher, err := controllers.NewConfigWatcher(path, GinkgoLogr)
+			Expect(err).ToNot(HaveOccurred())
+			r.ConfigWatcher = configWatcher
+		})
+
+		It("writes inline CA data", func(ctx SpecContext) {
+			r.LocalConfig = &rest.Config{TLSClientConfig: rest.TLSClientConfig{CAData: []byte("inline-ca")}}
+			Expect(r.RotateNow(ctx, secretKey)).To(Succeed())
+			var result corev1.Secret
+			Expect(gardenFake.Get(ctx, secretKey, &result)).To(Succeed())
+			Expect(result.Data).To(HaveKeyWithValue("ca.crt", BeEquivalentTo("inline-ca")))
+		})
+
+		It("reads the CA file", func(ctx SpecContext) {
+			caFile := filepath.Join(GinkgoT().TempDir(), "ca.crt")
+			Expect(os.WriteFile(caFile, []byte("file-ca"), 0644)).To(Succeed())
+			r.LocalConfig = &rest.Config{TLSClientConfig: rest.TLSClientConfig{CAFile: caFile}}
+			Expect(r.RotateNow(ctx, secretKey)).To(Succeed())
+			var result corev1.Secret
+			Expect(gardenFake.Get(ctx, secretKey, &result)).To(Succeed())
+			Expect(result.Data).To(HaveKeyWithValue("ca.crt", BeEquivalentTo("file-ca")))
+		})
+
+		It("fails without a CA", func(ctx SpecContext) {
+			r.LocalConfig = &rest.Config{}
+			Expect(r.RotateNow(ctx, secretKey)).To(MatchError(ContainSubstring("has no CA data or CA file")))
+		})
+
+	})
+
+	It("refuses to manage secrets of another type", func(ctx SpecContext) {
+		path := filepath.Join(GinkgoT().TempDir(), "config.json")
+		Expect(os.WriteFile(path, []byte(`{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a","secretType":"metal.ironcore.dev/token"}]}`), 0644)).To(Succeed())
+		configWatcher, err := controllers.NewConfigWatcher(path, GinkgoLogr)
+		Expect(err).ToNot(HaveOccurred())
+		recorder := record.NewFakeRecorder(10)
+		r.ConfigWatcher = configWatcher
+		r.Recorder = recorder
+
+		Expect(r.RotateNow(ctx, secretKey)).To(MatchError(ContainSubstring("secret has type \"\" instead of \"metal.ironcore.dev/token\"")))
+		Expect(recorder.Events).To(Receive(HavePrefix("Warning " + controllers.EventReasonSecretTypeMismatch)))
+		var result corev1.Secret
+		Expect(gardenFake.Get(ctx, secretKey, &result)).To(Succeed())
+		Expect(result.Data).To(HaveKeyWithValue("token", BeEquivalentTo("leaked-token")))
+	})
+
+	It("leaves paused secrets alone", func(ctx SpecContext) {
+		var secret corev1.Secret
+		Expect(gardenFake.Get(ctx, secretKey, &secret)).To(Succeed())
+		secret.Annotations[controllers.AutoprovisionPausedAnnotationKey] = "true"
+		Expect(gardenFake.Update(ctx, &secret)).To(Succeed())
+		recorder := record.NewFakeRecorder(10)
+		r.Recorder = recorder
+
+		Expect(r.RotateNow(ctx, secretKey)).To(MatchError(ContainSubstring("was not rotated: Paused")))
+		Expect(recorder.Events).To(Receive(HavePrefix("Normal " + controllers.EventReasonAutoprovisionPaused)))
+		var result corev1.Secret
+		Expect(gardenFake.Get(ctx, secretKey, &result)).To(Succeed())
+		Expect(result.Data).To(HaveKeyWithValue("token", BeEquivalentTo("leaked-token")))
+	})
+
+	It("records the time of successful reconciles", func(ctx SpecContext) {
+		now := time.Unix(1700000000, 0)
+		controllers.Now = func() time.Time { return now }
+		DeferCleanup(func() { controllers.Now = time.Now })
+
+		Expect(r.RotateNow(ctx, secretKey)).To(Succeed())
+		Expect(testutil.ToFloat64(controllers.LastReconcileTimestamp)).To(Equal(float64(now.Unix())))
+		Expect(testutil.ToFloat64(controllers.IdentityLastReconcileTimestamp.WithLabelValues("cluster-a"))).To(Equal(float64(now.Unix())))
+
+		now = now.Add(time.Minute)
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKey{Namespace: "garden", Name: "missing"}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(testutil.ToFloat64(controllers.LastReconcileTimestamp)).To(Equal(float64(now.Unix())))
+		Expect(testutil.ToFloat64(controllers.IdentityLastReconcileTimestamp.WithLabelValues("cluster-a"))).To(Equal(float64(now.Add(-time.Minute).Unix())))
+	})
+
+	It("reports secrets without matching config", func(ctx SpecContext) {
+		var secret corev1.Secret
+		Expect(gardenFake.Get(ctx, secretKey, &secret)).To(Succeed())
+		secret.Annotations[controllers.AutoprovisionAnnotationKey] = "cluster-b/metal"
+		Expect(gardenFake.Update(ctx, &secret)).To(Succeed())
+		recorder := record.NewFakeRecorder(10)
+		r.Recorder = recorder
+		skippedBefore := testutil.ToFloat64(controllers.SecretsSkippedTotal.WithLabelValues("NoMatchingConfig"))
+
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: secretKey})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(recorder.Events).To(Receive(Equal("Warning " + controllers.EventReasonNoMatchingConfig + " no cluster config for identity cluster-b")))
+		Expect(testutil.ToFloat64(controllers.SecretsSkippedTotal.WithLabelValues("NoMatchingConfig"))).To(Equal(skippedBefore + 1))
+	})
+
+	It("uses the configured annotation keys", func(ctx SpecContext) {
+		keys, err := controllers.NewAnnotationKeys("tokens.example.com")
+		Expect(err).ToNot(HaveOccurred())
+		r.AnnotationKeys = keys
+		Expect(r.RotateNow(ctx, secretKey)).To(MatchError(ContainSubstring("was not rotated: NoAnnotation")))
+
+		var secret corev1.Secret
+		Expect(gardenFake.Get(ctx, secretKey, &secret)).To(Succeed())
+		secret.Annotations = map[string]string{keys.Autoprovision: "cluster-a/metal"}
+		Expect(gardenFake.Update(ctx, &secret)).To(Succeed())
+		Expect(r.RotateNow(ctx, secretKey)).To(Succeed())
+	})
+
+	It("reports invalid target kubeconfigs on the secret", func(ctx SpecContext) {
+		path := filepath.Join(GinkgoT().TempDir(), "config.json")
+		Expect(os.WriteFile(path, []byte(`{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a","targetSecretName":"kubeconfig","targetSecretNamespace":"metal"}]}`), 0644)).To(Succeed())
+		configWatcher, err := controllers.NewConfigWatcher(path, GinkgoLogr)
+		Expect(err).ToNot(HaveOccurred())
+		recorder := record.NewFakeRecorder(10)
+		r.ConfigWatcher = configWatcher
+		r.Recorder = recorder
+		r.LocalClient = fake.NewClientBuilder().WithObjects(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "kubeconfig", Namespace: "metal"},
+			Data:       map[string][]byte{"kubeconfig": []byte("apiVersion: v1\nkind: Config\n")},
+		}).Build()
+
+		Expect(r.RotateNow(ctx, secretKey)).To(MatchError(ContainSubstring("current-context is not set")))
+		Expect(recorder.Events).To(Receive(Equal("Warning " + controllers.EventReasonInvalidTargetKubeconfig +
+			" target secret metal/kubeconfig of identity cluster-a: invalid kubeconfig in target secret: current-context is not set")))
+	})
+
+	It("rotates fresh tokens once for each new rotation generation", func(ctx SpecContext) {
+		useGeneration := func(generation int) {
+			path := filepath.Join(GinkgoT().TempDir(), "config.json")
+			Expect(os.WriteFile(path, []byte(fmt.Sprintf(`{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a","rotationGeneration":%d}]}`, generation)), 0644)).To(Succeed())
+			configWatcher, err := controllers.NewConfigWatcher(path, GinkgoLogr)
+			Expect(err).ToNot(HaveOccurred())
+			r.ConfigWatcher = configWatcher
+		}
+		var generation, issued int
+		r.LocalClient = fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+			SubResourceCreate: func(ctx context.Context, c client.Client, subResourceName string, obj, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+				issued++
+				now := time.Now()
+				subResource.(*authenticationv1.TokenRequest).Status.Token = fakeToken(now.Add(-time.Duration(issued)*time.Second), now.Add(time.Hour))
+				return nil
+			},
+		}).Build()
+		reconcileSecret := func() string {
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: secretKey})
+			Expect(err).ToNot(HaveOccurred())
+			var secret corev1.Secret
+			Expect(gardenFake.Get(ctx, secretKey, &secret)).To(Succeed())
+			Expect(secret.Annotations).To(HaveKeyWithValue(controllers.RotationGenerationAnnotationKey, strconv.Itoa(generation)))
+			return string(secret.Data["token"])
+		}
+
+		generation = 1
+		useGeneration(generation)
+		first := reconcileSecret()
+		Expect(reconcileSecret()).To(Equal(first))
+		generation = 2
+		useGeneration(generation)
+		Expect(reconcileSecret()).ToNot(Equal(first))
+		Expect(issued).To(Equal(2))
+	})
+
+	It("writes a token per namespace of the annotation", func(ctx SpecContext) {
+		path := filepath.Join(GinkgoT().TempDir(), "config.json")
+		Expect(os.WriteFile(path, []byte(`{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"{{ .TargetNamespace }}","identity":"cluster-a"}]}`), 0644)).To(Succeed())
+		configWatcher, err := controllers.NewConfigWatcher(path, GinkgoLogr)
+		Expect(err).ToNot(HaveOccurred())
+		r.ConfigWatcher = configWatcher
+		var requested []string
+		r.LocalClient = fake.NewClientBuilder().WithObjects(
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns1"}},
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns2"}},
+		).WithInterceptorFuncs(interceptor.Funcs{
+			SubResourceCreate: func(ctx context.Context, c client.Client, subResourceName string, obj, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+				requested = append(requested, obj.GetNamespace())
+				subResource.(*authenticationv1.TokenRequest).Status.Token = "token-for-" + obj.GetNamespace()
+				return nil
+			},
+		}).Build()
+		var secret corev1.Secret
+		Expect(gardenFake.Get(ctx, secretKey, &secret)).To(Succeed())
+		secret.Annotations[controllers.AutoprovisionAnnotationKey] = "cluster-a/ns1,ns2"
+		Expect(gardenFake.Update(ctx, &secret)).To(Succeed())
+
+		Expect(r.RotateNow(ctx, secretKey)).To(Succeed())
+		Expect(requested).To(Equal([]string{"ns1", "ns2"}))
+		Expect(gardenFake.Get(ctx, secretKey, &secret)).To(Succeed())
+		Expect(secret.Data).To(HaveKeyWithValue("token.ns1", BeEquivalentTo("token-for-ns1")))
+		Expect(secret.Data).To(HaveKeyWithValue("token.ns2", BeEquivalentTo("token-for-ns2")))
+		Expect(secret.Data).To(HaveKeyWithValue("namespace.ns1", BeEquivalentTo("ns1")))
+		Expect(secret.Data).To(HaveKeyWithValue("namespace.ns2", BeEquivalentTo("ns2")))
+		Expect(secret.Data).To(HaveKeyWithValue("username", BeEquivalentTo("sa")))
+		// the token of the single namespace annotation is removed
+		Expect(secret.Data).ToNot(HaveKey("token"))
+		Expect(secret.Data).ToNot(HaveKey("namespace"))
+	})
+
+	It("does not patch secrets that are up to date", func(ctx SpecContext) {
+		now := time.Now()
+		r.LocalClient = fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+			SubResourceCreate: func(ctx context.Context, c client.Client, subResourceName string, obj, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+				subResource.(*authenticationv1.TokenRequest).Status.Token = fakeToken(now, now.Add(time.Hour))
+				return nil
+			},
+		}).Build()
+		patches := 0
+		r.GardenClient = interceptor.NewClient(gardenFake.(client.WithWatch), interceptor.Funcs{
+			Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+				patches++
+				return c.Patch(ctx, obj, patch, opts...)
+			},
+		})
+
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: secretKey})
+		Expect(err).ToNot(HaveOccurred())
+		var first corev1.Secret
+		Expect(gardenFake.Get(ctx, secretKey, &first)).To(Succeed())
+		result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: secretKey})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.RequeueAfter).To(BeNumerically(">", 0))
+		var second corev1.Secret
+		Expect(gardenFake.Get(ctx, secretKey, &second)).To(Succeed())
+		Expect(patches).To(Equal(1))
+		Expect(second.ResourceVersion).To(Equal(first.ResourceVersion))
+	})
+
+	It("merges the configured labels into the labels of the secret", func(ctx SpecContext) {
+		writeConfig := func(labels string) {
+			path := filepath.Join(GinkgoT().TempDir(), "config.json")
+			Expect(os.WriteFile(path, []byte(`{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a","labels":`+labels+`}]}`), 0644)).To(Succeed())
+			configWatcher, err := controllers.NewConfigWatcher(path, GinkgoLogr)
+			Expect(err).ToNot(HaveOccurred())
+			r.ConfigWatcher = configWatcher
+		}
+		var secret corev1.Secret
+		Expect(gardenFake.Get(ctx, secretKey, &secret)).To(Succeed())
+		secret.Labels = map[string]string{"owner": "team-a", "tier": "dev"}
+		Expect(gardenFake.Update(ctx, &secret)).To(Succeed())
+
+		writeConfig(`{"tier":"prod","example.com/consumer":"gardener"}`)
+		Expect(r.RotateNow(ctx, secretKey)).To(Succeed())
+		Expect(gardenFake.Get(ctx, secretKey, &secret)).To(Succeed())
+		Expect(secret.Labels).To(Equal(map[string]string{
+			"owner":                       "team-a",
+			"tier":                        "prod",
+			"example.com/consumer":        "gardener",
+			controllers.ManagedByLabelKey: controllers.ManagedByLabelValue,
+		}))
+
+		// labels removed from the config are left alone
+		writeConfig(`{}`)
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: secretKey})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(gardenFake.Get(ctx, secretKey, &secret)).To(Succeed())
+		Expect(secret.Labels).To(HaveKeyWithValue("example.com/consumer", "gardener"))
+	})
+
+	It("writes tokens base64-encoded with base64EncodeTokens", func(ctx SpecContext) {
+		now := time.Now()
+		var tokens []string
+		r.LocalClient = fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+			SubResourceCreate: func(ctx context.Context, c client.Client, subResourceName string, obj, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+				token := fakeToken(now.Add(-time.Duration(len(tokens))*time.Second), now.Add(time.Hour))
+				tokens = append(tokens, token)
+				subResource.(*authenticationv1.TokenRequest).Status.Token = token
+				return nil
+			},
+		}).Build()
+		// a token written before the option was enabled
+		Expect(r.RotateNow(ctx, secretKey)).To(Succeed())
+		path := filepath.Join(GinkgoT().TempDir(), "config.json")
+		Expect(os.WriteFile(path, []byte(`{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a","base64EncodeTokens":true}]}`), 0644)).To(Succeed())
+		configWatcher, err := controllers.NewConfigWatcher(path, GinkgoLogr)
+		Expect(err).ToNot(HaveOccurred())
+		r.ConfigWatcher = configWatcher
+
+		_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: secretKey})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tokens).To(HaveLen(2))
+		var secret corev1.Secret
+		Expect(gardenFake.Get(ctx, secretKey, &secret)).To(Succeed())
+		Expect(secret.Data).To(HaveKeyWithValue("token", BeEquivalentTo(base64.StdEncoding.EncodeToString([]byte(tokens[1])))))
+
+		// the encoded token is recognized as fresh
+		_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: secretKey})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tokens).To(HaveLen(2))
+	})
+
+	Describe("with a renew-after annotation", func() {
+
+		var (
+			now         time.Time
+			recorder    *record.FakeRecorder
+			tokenClient *fakeTokenClient
+		)
+
+		BeforeEach(func(ctx SpecContext) {
+			now = time.Unix(1700000000, 0)
+			controllers.Now = func() time.Time { return now }
+			DeferCleanup(func() { controllers.Now = time.Now })
+			tokenClient = &fakeTokenClient{token: fakeToken(now, now.Add(time.Hour)), authenticated: true}
+			r.NewTokenClient = func(client.Client) controllers.TokenClient { return tokenClient }
+			Expect(r.RotateNow(ctx, secretKey)).To(Succeed())
+			recorder = record.NewFakeRecorder(10)
+			r.Recorder = recorder
+			// past the renewal threshold of 30 minutes
+			now = now.Add(40 * time.Minute)
+			tokenClient.token = fakeToken(now, now.Add(time.Hour))
+		})
+
+		setRenewAfter := func(ctx SpecContext, value string) {
+			var secret corev1.Secret
+			Expect(gardenFake.Get(ctx, secretKey, &secret)).To(Succeed())
+			secret.Annotations[controllers.RenewAfterAnnotationKey] = value
+			Expect(gardenFake.Update(ctx, &secret)).To(Succeed())
+		}
+
+		It("keeps the token until the annotated time", func(ctx SpecContext) {
+			setRenewAfter(ctx, now.Add(10*time.Minute).UTC().Format(time.RFC3339))
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: secretKey})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tokenClient.requests).To(HaveLen(1))
+			Expect(result.RequeueAfter).To(Equal(10 * time.Minute))
+
+			now = now.Add(10*time.Minute + time.Second)
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: secretKey})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tokenClient.requests).To(HaveLen(2))
+		})
+
+		It("requeues at the expiry of tokens pinned beyond it", func(ctx SpecContext) {
+			setRenewAfter(ctx, now.Add(time.Hour).UTC().Format(time.RFC3339))
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: secretKey})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tokenClient.requests).To(HaveLen(1))
+			Expect(result.RequeueAfter).To(Equal(20 * time.Minute))
+		})
+
+		It("ignores malformed times", func(ctx SpecContext) {
+			setRenewAfter(ctx, "next tuesday")
+			_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: secretKey})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tokenClient.requests).To(HaveLen(2))
+			Expect(recorder.Events).To(Receive(HavePrefix("Warning " + controllers.EventReasonInvalidRenewAfter)))
+		})
+
+	})
+
+	issuedAt := time.Unix(1700000000, 0)
+	dayToken := fakeToken(issuedAt, issuedAt.Add(24*time.Hour))
+	hourToken := fakeToken(issuedAt, issuedAt.Add(time.Hour))
+
+	DescribeTable("requeues at the requeue interval of the cluster",
+		func(ctx SpecContext, token string, interval string, expected time.Duration) {
+			path := filepath.Join(GinkgoT().TempDir(), "config.json")
+			Expect(os.WriteFile(path, []byte(`{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"`+interval+`}]}`), 0644)).To(Succeed())
+			configWatcher, err := controllers.NewConfigWatcher(path, GinkgoLogr)
+			Expect(err).ToNot(HaveOccurred())
+			r.ConfigWatcher = configWatcher
+			if token != "" {
+				r.LocalClient = fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+					SubResourceCreate: func(ctx context.Context, c client.Client, subResourceName string, obj, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+						subResource.(*authenticationv1.TokenRequest).Status.Token = token
+						return nil
+					},
+				}).Build()
+			}
+			controllers.Now = func() time.Time { return issuedAt }
+			DeferCleanup(func() { controllers.Now = time.Now })
+
+			// the leaked token is replaced, so the requeue follows the new one
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: secretKey})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(expected))
+		},
+		Entry("caps the requeue of long-lived tokens", dayToken, `,"requeueIntervalSeconds":7200`, 2*time.Hour),
+		Entry("allows requeues later than the default", dayToken, `,"requeueIntervalSeconds":14400`, 4*time.Hour),
+		Entry("keeps the renewal threshold of short-lived tokens", hourToken, `,"requeueIntervalSeconds":7200`, 30*time.Minute),
+		Entry("replaces the default for tokens without expiry", "", `,"requeueIntervalSeconds":600`, 10*time.Minute),
+		Entry("defaults to one hour", dayToken, "", time.Hour),
+		Entry("defaults to the global default for tokens without expiry", "", "", controllers.DefaultRequeueAfter),
+		Entry("requeues at the maxTokenAge of long-lived tokens", dayToken, `,"requeueIntervalSeconds":14400,"maxTokenAge":"3h"`, 3*time.Hour),
+	)
+
+	It("fails for secrets that are not managed", func(ctx SpecContext) {
+		Expect(r.RotateNow(ctx, client.ObjectKey{Namespace: "garden", Name: "missing"})).To(MatchError(ContainSubstring("was not rotated: SecretDeleted")))
+	})
+
+})
+
+var _ = Describe("ReconcileAll", func() {
+
+	var r *controllers.SecretReconciler
+
+	BeforeEach(func() {
+		path := filepath.Join(GinkgoT().TempDir(), "config.json")
+		Expect(os.WriteFile(path, []byte(`{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"}]}`), 0644)).To(Succeed())
+		configWatcher, err := controllers.NewConfigWatcher(path, GinkgoLogr)
+		Expect(err).ToNot(HaveOccurred())
+
+		secret := func(namespace, name, annotation string) *corev1.Secret {
+			s := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+			if annotation != "" {
+				s.Annotations = map[string]string{controllers.AutoprovisionAnnotationKey: annotation}
+			}
+			return s
+		}
+		r = &controllers.SecretReconciler{
+			GardenClient: fake.NewClientBuilder().WithObjects(
+				secret("garden-a", "managed", "cluster-a/metal"),
+				secret("garden-a", "unknown", "cluster-b/metal"),
+				secret("garden-a", "unannotated", ""),
+				secret("garden-b", "managed", "cluster-a/metal"),
+			).Build(),
+			LocalClient:   fake.NewClientBuilder().WithObjects(&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "sa", Namespace: "ns"}}).Build(),
+			Log:           GinkgoLogr,
+			ConfigWatcher: configWatcher,
+			Recorder:      &record.FakeRecorder{},
+		}
+	})
+
+	It("reconciles annotated secrets in all namespaces", func(ctx SpecContext) {
+		Expect(r.ReconcileAll(ctx)).To(Equal(map[string]int{"TokenIssued": 2, "NoMatchingConfig": 1}))
+	})
+
+	It("reconciles annotated secrets in the given namespaces", func(ctx SpecContext) {
+		Expect(r.ReconcileAll(ctx, "garden-b")).To(Equal(map[string]int{"TokenIssued": 1}))
+	})
+
+	It("reports failed secrets", func(ctx SpecContext) {
+		r.LocalClient = fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+			SubResourceCreate: func(ctx context.Context, c client.Client, subResourceName string, obj, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+				return errors.New("metal cluster unavailable")
+			},
+		}).Build()
+		reasons, err := r.ReconcileAll(ctx)
+		Expect(reasons).To(Equal(map[string]int{"Error": 2, "NoMatchingConfig": 1}))
+		Expect(err).To(MatchError(And(ContainSubstring("garden-a/managed: "), ContainSubstring("garden-b/managed: "), ContainSubstring("metal cluster unavailable"))))
+	})
+
+})
+
+var _ = Describe("Rotation loop detection", func() {
+
+	It("backs off from secrets whose tokens are rotated on every reconcile", func(ctx SpecContext) {
+		path := filepath.Join(GinkgoT().TempDir(), "config.json")
+		Expect(os.WriteFile(path, []byte(`{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"}]}`), 0644)).To(Succeed())
+		configWatcher, err := controllers.NewConfigWatcher(path, GinkgoLogr)
+		Expect(err).ToNot(HaveOccurred())
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "metal-token",
+				Namespace:   "garden",
+				Annotations: map[string]string{controllers.AutoprovisionAnnotationKey: "cluster-a/metal"},
+			},
+		}
+		recorder := record.NewFakeRecorder(100)
+		var issued int
+		r := &controllers.SecretReconciler{
+			GardenClient: fake.NewClientBuilder().WithObjects(secret).Build(),
+			// tokens that are not JWTs are rotated on every reconcile
+			LocalClient: fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+				SubResourceCreate: func(ctx context.Context, c client.Client, subResourceName string, obj, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+					issued++
+					subResource.(*authenticationv1.TokenRequest).Status.Token = fmt.Sprintf("opaque-token-%d", issued)
+					return nil
+				},
+			}).Build(),
+			Log:           GinkgoLogr,
+			ConfigWatcher: configWatcher,
+			Recorder:      recorder,
+		}
+		req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(secret)}
+
+		// the first token is issued, then replaced 5 times
+		for range 6 {
+			_, err := r.Reconcile(ctx, req)
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Expect(r.Reconcile(ctx, req)).To(Equal(ctrl.Result{RequeueAfter: 30 * time.Minute}))
+		Eventually(recorder.Events).Should(Receive(HavePrefix("Warning " + controllers.EventReasonRotationLoopDetected + " tokens were replaced before they were due 5 times within 10m0s")))
+	})
+
+	It("does not count rotations of tokens that are due", func(ctx SpecContext) {
+		path := filepath.Join(GinkgoT().TempDir(), "config.json")
+		Expect(os.WriteFile(path, []byte(`{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a","expirationSeconds":600,"renewalThresholdPercent":5,"skipTokenReview":true}]}`), 0644)).To(Succeed())
+		configWatcher, err := controllers.NewConfigWatcher(path, GinkgoLogr)
+		Expect(err).ToNot(HaveOccurred())
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "metal-token",
+				Namespace:   "garden",
+				Annotations: map[string]string{controllers.AutoprovisionAnnotationKey: "cluster-a/metal"},
+			},
+		}
+		now := time.Unix(1700000000, 0)
+		controllers.Now = func() time.Time { return now }
+		DeferCleanup(func() { controllers.Now = time.Now })
+		recorder := record.NewFakeRecorder(100)
+		r := &controllers.SecretReconciler{
+			GardenClient: fake.NewClientBuilder().WithObjects(secret).Build(),
+			LocalClient: fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+				SubResourceCreate: func(ctx context.Context, c client.Client, subResourceName string, obj, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+					subResource.(*authenticationv1.TokenRequest).Status.Token = fakeToken(now, now.Add(10*time.Minute))
+					return nil
+				},
+			}).Build(),
+			Log:           GinkgoLogr,
+			ConfigWatcher: configWatcher,
+			Recorder:      recorder,
+		}
+		req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(secret)}
+
+		// 5% of 10 minutes is due every 30 seconds
+		for range 10 {
+			result, err := r.Reconcile(ctx, req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeNumerically("<", 30*time.Minute))
+			now = now.Add(time.Minute)
+		}
+		Expect(recorder.Events).ToNot(Receive(HavePrefix("Warning " + controllers.EventReasonRotationLoopDetected)))
+	})
+
+})
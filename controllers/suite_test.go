@@ -33,6 +33,9 @@ func TestControllers(t *testing.T) {
 const (
 	serviceAccountName string = "test-service-account"
 	identity           string = "test-cluster"
+	kubeconfigIdentity string = "test-cluster-kubeconfig"
+	multiTokenIdentity string = "test-cluster-multi-token"
+	verifyNsIdentity   string = "test-cluster-verify-namespace"
 )
 
 var (
@@ -45,10 +48,25 @@ var (
 	stopController context.CancelFunc
 )
 
+// envtestAvailable is false without KUBEBUILDER_ASSETS, e.g. for a plain go
+// test. Only the specs labeled "envtest" need the API servers, the others
+// use fake clients and run anyway.
+var envtestAvailable = os.Getenv("KUBEBUILDER_ASSETS") != ""
+
+// skipWithoutEnvtest skips the current spec if the API servers are not running.
+func skipWithoutEnvtest() {
+	if !envtestAvailable {
+		Skip("KUBEBUILDER_ASSETS is not set, skipping envtest specs")
+	}
+}
+
 var _ = BeforeSuite(func() {
 	logf.SetLogger(zap.New(zap.WriteTo(GinkgoWriter), zap.UseDevMode(true)))
 
 	Expect(corev1.AddToScheme(clientgoscheme.Scheme)).To(Succeed())
+	if !envtestAvailable {
+		return
+	}
 
 	By("bootstrapping metal cluster")
 	metalEnv = &envtest.Environment{}
@@ -80,14 +98,6 @@ var _ = BeforeSuite(func() {
 	Expect(err).To(Succeed())
 
 	configPath := "test.json"
-	reconciler := &controllers.SecretReconciler{
-		LocalClient:  metalClient,
-		GardenClient: gardenClient,
-		Log:          GinkgoLogr,
-		ConfigPath:   configPath,
-	}
-	Expect(reconciler.SetupWithManager(mgr)).To(Succeed())
-
 	config := controllers.Config{
 		Clusters: []controllers.ClusterConfig{
 			{
@@ -96,12 +106,52 @@ var _ = BeforeSuite(func() {
 				ExpirationSeconds:       600,
 				Identity:                identity,
 			},
+			{
+				ServiceAccountName:      serviceAccount.Name,
+				ServiceAccountNamespace: serviceAccount.Namespace,
+				ExpirationSeconds:       600,
+				Identity:                kubeconfigIdentity,
+				EmitKubeconfig:          true,
+			},
+			{
+				ServiceAccountName:      serviceAccount.Name,
+				ServiceAccountNamespace: serviceAccount.Namespace,
+				ExpirationSeconds:       600,
+				Identity:                multiTokenIdentity,
+				AdditionalTokens: []controllers.TokenConfig{
+					{
+						ServiceAccountName:      serviceAccount.Name,
+						ServiceAccountNamespace: serviceAccount.Namespace,
+						TokenKey:                "secondToken",
+					},
+				},
+			},
+			{
+				ServiceAccountName:      serviceAccount.Name,
+				ServiceAccountNamespace: serviceAccount.Namespace,
+				ExpirationSeconds:       600,
+				Identity:                verifyNsIdentity,
+				VerifyTargetNamespace:   true,
+			},
 		},
 	}
 	data, err := json.Marshal(config)
 	Expect(err).To(Succeed())
 	Expect(os.WriteFile(configPath, data, 0644)).To(Succeed())
 
+	configWatcher, err := controllers.NewConfigWatcher(configPath, GinkgoLogr)
+	Expect(err).To(Succeed())
+	Expect(mgr.Add(configWatcher)).To(Succeed())
+
+	reconciler := &controllers.SecretReconciler{
+		LocalClient:   metalClient,
+		LocalConfig:   metalCfg,
+		GardenClient:  gardenClient,
+		Log:           GinkgoLogr,
+		ConfigWatcher: configWatcher,
+	}
+	Expect(reconciler.SetupWithManager(mgr)).To(Succeed())
+
 	go func() {
 		err := mgr.Start(ctx)
 		Expect(err).To(Succeed())
@@ -109,6 +159,9 @@ var _ = BeforeSuite(func() {
 })
 
 var _ = AfterSuite(func() {
+	if !envtestAvailable {
+		return
+	}
 	stopController()
 	Expect(os.Remove("test.json")).To(Succeed())
 	By("tearing down the garden cluster")
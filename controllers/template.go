@@ -0,0 +1,139 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// templateData is the complete set of variables available in templates:
+//
+//	{{ .SecretName }}        name of the managed secret
+//	{{ .SecretNamespace }}   namespace of the managed secret
+//	{{ label "<key>" }}      label of the managed secret
+//	{{ .TargetNamespace }}   resolved namespace of the autoprovision annotation,
+//	                         only set for service account namespaces
+//
+// Templates cannot contain any other actions, see parseTemplate. Unknown
+// variables and missing labels make parsing or rendering fail.
+type templateData struct {
+	SecretName      string
+	SecretNamespace string
+	TargetNamespace string
+
+	labels map[string]string
+}
+
+func newTemplateData(secret *corev1.Secret) templateData {
+	return templateData{
+		SecretName:      secret.Name,
+		SecretNamespace: secret.Namespace,
+		labels:          secret.Labels,
+	}
+}
+
+// label looks up a label of the secret, unlike map lookups it fails if the label is missing.
+func (d templateData) label(key string) (string, error) {
+	value, ok := d.labels[key]
+	if !ok {
+		return "", fmt.Errorf("secret has no label %q", key)
+	}
+	return value, nil
+}
+
+func isTemplate(text string) bool {
+	return strings.Contains(text, "{{")
+}
+
+// maxRenderedLength is the longest rendered template, the maximum length
+// of a namespace name.
+const maxRenderedLength = 63
+
+// templateFields are the fields of templateData that templates may use.
+var templateFields = []string{"SecretName", "SecretNamespace", "TargetNamespace"}
+
+// parseTemplate parses text and checks that it only contains text, fields of
+// templateData and label calls. Templates come from annotations anyone with
+// access to a garden secret can set, so actions like range, which can make
+// rendering arbitrarily expensive, are rejected.
+func parseTemplate(text string) (*template.Template, error) {
+	// the functions are bound to the rendered secret in renderTemplate
+	tmpl, err := template.New("").Funcs(template.FuncMap{"label": templateData{}.label}).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(tmpl.Templates()) > 1 {
+		return nil, errors.New("template definitions are not supported")
+	}
+	for _, node := range tmpl.Root.Nodes {
+		if err := checkTemplateNode(node); err != nil {
+			return nil, err
+		}
+	}
+	return tmpl, nil
+}
+
+func checkTemplateNode(node parse.Node) error {
+	switch node := node.(type) {
+	case *parse.TextNode:
+		return nil
+	case *parse.ActionNode:
+		if len(node.Pipe.Decl) == 0 && len(node.Pipe.Cmds) == 1 && isAllowedCommand(node.Pipe.Cmds[0]) {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported template action %s, only .SecretName, .SecretNamespace, .TargetNamespace and label \"<key>\" are supported", node)
+}
+
+func isAllowedCommand(cmd *parse.CommandNode) bool {
+	switch len(cmd.Args) {
+	case 1:
+		field, ok := cmd.Args[0].(*parse.FieldNode)
+		return ok && len(field.Ident) == 1 && slices.Contains(templateFields, field.Ident[0])
+	case 2:
+		function, ok := cmd.Args[0].(*parse.IdentifierNode)
+		_, isString := cmd.Args[1].(*parse.StringNode)
+		return ok && function.Ident == "label" && isString
+	}
+	return false
+}
+
+// limitedWriter fails writes beyond its remaining bytes.
+type limitedWriter struct {
+	b         strings.Builder
+	remaining int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) > w.remaining {
+		return 0, fmt.Errorf("rendered template is longer than %d bytes", maxRenderedLength)
+	}
+	w.remaining -= len(p)
+	return w.b.Write(p)
+}
+
+// renderTemplate renders text with data. Text without template actions is
+// returned unchanged.
+func renderTemplate(text string, data templateData) (string, error) {
+	if !isTemplate(text) {
+		return text, nil
+	}
+	tmpl, err := parseTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	tmpl.Funcs(template.FuncMap{"label": data.label})
+	w := &limitedWriter{remaining: maxRenderedLength}
+	if err := tmpl.Execute(w, data); err != nil {
+		return "", fmt.Errorf("failed to render %q: %w", text, err)
+	}
+	return w.b.String(), nil
+}
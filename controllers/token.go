@@ -0,0 +1,124 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultRequeueAfter is the default requeue interval for tokens whose
+	// expiry cannot be determined.
+	DefaultRequeueAfter = 2 * time.Minute
+	// DefaultMinRotationInterval is the default for the shortest token age
+	// at which tokens are rotated.
+	DefaultMinRotationInterval = 5 * time.Minute
+	// DefaultClientTimeout is the default timeout for a single API call.
+	DefaultClientTimeout = 30 * time.Second
+	minRequeueAfter      = 30 * time.Second
+	maxRequeueAfter      = time.Hour
+	// serviceAccountNotFoundRequeueAfter is longer than the error backoff,
+	// since a missing service account usually has to be created by a human
+	serviceAccountNotFoundRequeueAfter = 15 * time.Minute
+	// conflictRequeueAfter is short, since the next reconcile starts from the
+	// concurrently modified secret and usually succeeds
+	conflictRequeueAfter = time.Second
+	// lifetimeTolerancePercent is how much an issued token's lifetime may
+	// differ from the requested one before it is logged
+	lifetimeTolerancePercent = 10
+	// reviewWindowPercent is the share of the renewal age during which tokens
+	// are trusted without a TokenReview. Revoked tokens are detected once
+	// they are older.
+	reviewWindowPercent = 80
+)
+
+// errMissingTimeClaims is returned for tokens whose age cannot be determined
+// because iat or exp is missing or exp is not after iat.
+var errMissingTimeClaims = errors.New("token lacks usable iat and exp claims")
+
+// errMalformedToken is returned for tokens that are not structured like a JWT
+// or whose payload cannot be decoded.
+var errMalformedToken = errors.New("token is not a JWT")
+
+type jwtClaims struct {
+	Exp int64 `json:"exp"`
+	Iat int64 `json:"iat"`
+}
+
+func (c jwtClaims) issuedAt() time.Time {
+	return time.Unix(c.Iat, 0)
+}
+
+func (c jwtClaims) expiresAt() time.Time {
+	return time.Unix(c.Exp, 0)
+}
+
+func (c jwtClaims) lifetime() time.Duration {
+	return c.expiresAt().Sub(c.issuedAt())
+}
+
+// renewalAge is the token age after which the token should be rotated. It is
+// at least minInterval, unless the token expires earlier.
+func (c jwtClaims) renewalAge(thresholdPercent int64, minInterval time.Duration) time.Duration {
+	return max(c.lifetime()*time.Duration(thresholdPercent)/100, min(minInterval, c.lifetime()))
+}
+
+func parseTokenClaims(token string) (jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) < 2 {
+		return jwtClaims{}, errMalformedToken
+	}
+	decodedPayload, err := decodeSegment(parts[1])
+	if err != nil {
+		return jwtClaims{}, fmt.Errorf("%w: failed to decode payload: %w", errMalformedToken, err)
+	}
+
+	var claims jwtClaims
+	err = json.Unmarshal(decodedPayload, &claims)
+	if err != nil {
+		return jwtClaims{}, fmt.Errorf("%w: failed to unmarshal claims: %w", errMalformedToken, err)
+	}
+	if claims.Iat == 0 || claims.Exp == 0 || claims.Exp <= claims.Iat {
+		return jwtClaims{}, errMissingTimeClaims
+	}
+	return claims, nil
+}
+
+// decodeSegment decodes a JWT segment. JWTs use unpadded base64url, but some
+// issuers pad their segments or use the standard alphabet.
+func decodeSegment(segment string) ([]byte, error) {
+	segment = strings.TrimRight(segment, "=")
+	decoded, err := base64.RawURLEncoding.DecodeString(segment)
+	if err == nil {
+		return decoded, nil
+	}
+	if decoded, stdErr := base64.RawStdEncoding.DecodeString(segment); stdErr == nil {
+		return decoded, nil
+	}
+	return nil, err
+}
+
+// requeueAfter returns the time until the token crosses its renewal
+// threshold minus clockSkew, clamped to [minRequeueAfter, ceiling].
+// Tokens that cannot be parsed are requeued after fallback.
+func requeueAfter(token string, thresholdPercent int64, minInterval, clockSkew, fallback, ceiling time.Duration) time.Duration {
+	claims, err := parseTokenClaims(token)
+	if err != nil {
+		return fallback
+	}
+	renewAt := claims.issuedAt().Add(claims.renewalAge(thresholdPercent, minInterval) - clockSkew)
+	return min(max(renewAt.Sub(Now()), minRequeueAfter), ceiling)
+}
+
+// lifetimeDiverges reports whether actual differs from requested by more than
+// lifetimeTolerancePercent, e.g. because the API server caps token lifetimes.
+func lifetimeDiverges(requested, actual time.Duration) bool {
+	diff := (requested - actual).Abs()
+	return diff*100 > requested*lifetimeTolerancePercent
+}
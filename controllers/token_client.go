@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// TokenClient requests and reviews tokens in a metal cluster. Errors are
+// expected to be API errors like those of a client.Client, so that missing
+// service accounts and permissions are recognized.
+type TokenClient interface {
+	// CreateToken requests a token for account and stores it in the status
+	// of request.
+	CreateToken(ctx context.Context, account *corev1.ServiceAccount, request *authenticationv1.TokenRequest) error
+	// ReviewToken reviews the token in the spec of review and stores the
+	// result in its status.
+	ReviewToken(ctx context.Context, review *authenticationv1.TokenReview) error
+}
+
+// NewTokenClient returns a TokenClient using the token subresource and
+// TokenReviews of the metal cluster behind metalClient.
+func NewTokenClient(metalClient client.Client) TokenClient {
+	return apiTokenClient{client: metalClient}
+}
+
+type apiTokenClient struct {
+	client client.Client
+}
+
+// CreateToken implements TokenClient.
+func (c apiTokenClient) CreateToken(ctx context.Context, account *corev1.ServiceAccount, request *authenticationv1.TokenRequest) error {
+	return c.client.SubResource("token").Create(ctx, account, request)
+}
+
+// ReviewToken implements TokenClient.
+func (c apiTokenClient) ReviewToken(ctx context.Context, review *authenticationv1.TokenReview) error {
+	return c.client.Create(ctx, review)
+}
+
+// tokenClient returns the TokenClient for the metal cluster behind metalClient.
+func (r *SecretReconciler) tokenClient(metalClient client.Client) TokenClient {
+	if r.NewTokenClient == nil {
+		return NewTokenClient(metalClient)
+	}
+	return r.NewTokenClient(metalClient)
+}
@@ -0,0 +1,156 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/ironcore-dev/metal-token-rotate/controllers"
+)
+
+// fakeTokenClient issues token for every request and answers every review
+// with authenticated, unless the respective error is set.
+type fakeTokenClient struct {
+	token         string
+	authenticated bool
+	createErr     error
+	reviewErr     error
+
+	accounts []string
+	requests []authenticationv1.TokenRequestSpec
+	reviewed []string
+}
+
+func (c *fakeTokenClient) CreateToken(_ context.Context, account *corev1.ServiceAccount, request *authenticationv1.TokenRequest) error {
+	c.accounts = append(c.accounts, account.Namespace+"/"+account.Name)
+	c.requests = append(c.requests, request.Spec)
+	if c.createErr != nil {
+		return c.createErr
+	}
+	request.Status.Token = c.token
+	return nil
+}
+
+func (c *fakeTokenClient) ReviewToken(_ context.Context, review *authenticationv1.TokenReview) error {
+	c.reviewed = append(c.reviewed, review.Spec.Token)
+	if c.reviewErr != nil {
+		return c.reviewErr
+	}
+	review.Status.Authenticated = c.authenticated
+	return nil
+}
+
+var _ = Describe("ensureToken with a fake token client", func() {
+
+	var (
+		now         time.Time
+		tokenClient *fakeTokenClient
+		recorder    *record.FakeRecorder
+		reconciler  *controllers.SecretReconciler
+		secret      *corev1.Secret
+	)
+
+	BeforeEach(func() {
+		now = time.Unix(1700000000, 0)
+		controllers.Now = func() time.Time { return now }
+		DeferCleanup(func() { controllers.Now = time.Now })
+		tokenClient = &fakeTokenClient{token: fakeToken(now, now.Add(10*time.Minute)), authenticated: true}
+		recorder = record.NewFakeRecorder(10)
+		reconciler = &controllers.SecretReconciler{
+			Log:      GinkgoLogr,
+			Recorder: recorder,
+			NewTokenClient: func(client.Client) controllers.TokenClient {
+				return tokenClient
+			},
+		}
+		secret = &corev1.Secret{}
+	})
+
+	It("issues a token if there is none", func(ctx SpecContext) {
+		Expect(reconciler.EnsureToken(ctx, nil, secret, "", false)).To(Equal(tokenClient.token))
+		Expect(tokenClient.accounts).To(Equal([]string{"ns/sa"}))
+		Expect(tokenClient.requests).To(HaveLen(1))
+		Expect(*tokenClient.requests[0].ExpirationSeconds).To(BeNumerically("==", 600))
+		Expect(tokenClient.reviewed).To(BeEmpty())
+	})
+
+	It("keeps a fresh token without reviewing it", func(ctx SpecContext) {
+		token := fakeToken(now.Add(-time.Minute), now.Add(9*time.Minute))
+		Expect(reconciler.EnsureToken(ctx, nil, secret, token, false)).To(Equal(token))
+		Expect(tokenClient.reviewed).To(BeEmpty())
+		Expect(tokenClient.requests).To(BeEmpty())
+	})
+
+	It("keeps a reviewed token below the renewal threshold", func(ctx SpecContext) {
+		token := fakeToken(now.Add(-270*time.Second), now.Add(330*time.Second))
+		Expect(reconciler.EnsureToken(ctx, nil, secret, token, false)).To(Equal(token))
+		Expect(tokenClient.reviewed).To(Equal([]string{token}))
+		Expect(tokenClient.requests).To(BeEmpty())
+	})
+
+	It("rotates a token past the renewal threshold", func(ctx SpecContext) {
+		token := fakeToken(now.Add(-6*time.Minute), now.Add(4*time.Minute))
+		Expect(reconciler.EnsureToken(ctx, nil, secret, token, false)).To(Equal(tokenClient.token))
+	})
+
+	It("rotates a token that is not authenticated", func(ctx SpecContext) {
+		tokenClient.authenticated = false
+		token := fakeToken(now.Add(-270*time.Second), now.Add(330*time.Second))
+		Expect(reconciler.EnsureToken(ctx, nil, secret, token, false)).To(Equal(tokenClient.token))
+	})
+
+	It("rotates a malformed token without reviewing it", func(ctx SpecContext) {
+		Expect(reconciler.EnsureToken(ctx, nil, secret, "header.!!!.signature", false)).To(Equal(tokenClient.token))
+		Expect(tokenClient.reviewed).To(BeEmpty())
+	})
+
+	It("rotates a fresh token when forced", func(ctx SpecContext) {
+		token := fakeToken(now.Add(-time.Minute), now.Add(9*time.Minute))
+		Expect(reconciler.EnsureToken(ctx, nil, secret, token, true)).To(Equal(tokenClient.token))
+		Expect(tokenClient.reviewed).To(BeEmpty())
+	})
+
+	It("never reviews tokens with SkipTokenReview", func(ctx SpecContext) {
+		tokenClient.authenticated = false
+		kept := fakeToken(now.Add(-270*time.Second), now.Add(330*time.Second))
+		Expect(reconciler.NeedsTokenWithoutReview(ctx, nil, kept)).To(BeFalse())
+		future := fakeToken(now.Add(time.Minute), now.Add(11*time.Minute))
+		Expect(reconciler.NeedsTokenWithoutReview(ctx, nil, future)).To(BeFalse())
+		old := fakeToken(now.Add(-6*time.Minute), now.Add(4*time.Minute))
+		Expect(reconciler.NeedsTokenWithoutReview(ctx, nil, old)).To(BeTrue())
+		Expect(tokenClient.reviewed).To(BeEmpty())
+	})
+
+	It("fails if the token review fails", func(ctx SpecContext) {
+		tokenClient.reviewErr = errors.New("connection refused")
+		token := fakeToken(now.Add(-270*time.Second), now.Add(330*time.Second))
+		_, err := reconciler.EnsureToken(ctx, nil, secret, token, false)
+		Expect(err).To(MatchError("failed to check if token is needed: failed to create token review: connection refused"))
+		Expect(recorder.Events).To(Receive(ContainSubstring(controllers.EventReasonTokenReviewFailed)))
+		Expect(tokenClient.requests).To(BeEmpty())
+	})
+
+	It("reports a missing service account", func(ctx SpecContext) {
+		tokenClient.createErr = apierrors.NewNotFound(corev1.Resource("serviceaccounts"), "sa")
+		_, err := reconciler.EnsureToken(ctx, nil, secret, "", false)
+		Expect(err).To(MatchError("service account ns/sa does not exist in the metal cluster"))
+	})
+
+	It("reports missing permissions to request tokens", func(ctx SpecContext) {
+		tokenClient.createErr = apierrors.NewForbidden(corev1.Resource("serviceaccounts/token"), "sa", errors.New("RBAC denied"))
+		_, err := reconciler.EnsureToken(ctx, nil, secret, "", false)
+		Expect(err).To(MatchError(ContainSubstring("not allowed to request tokens for service account ns/sa")))
+	})
+
+})
@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/ironcore-dev/metal-token-rotate/api/v1alpha1"
+)
+
+// updateTokenRotation records the outcome of a reconcile in the TokenRotation
+// of the secret, creating it if necessary. Failures are only logged, so that
+// the status cannot get in the way of rotating tokens.
+func (r *SecretReconciler) updateTokenRotation(ctx context.Context, log logr.Logger, secret *corev1.Secret, identity string, result ctrl.Result, reason reconcileReason, reconcileErr error) {
+	ctx, cancel := r.withClientTimeout(ctx)
+	defer cancel()
+	var rotation v1alpha1.TokenRotation
+	err := r.GardenClient.Get(ctx, client.ObjectKeyFromObject(secret), &rotation)
+	if apierrors.IsNotFound(err) {
+		rotation = v1alpha1.TokenRotation{
+			ObjectMeta: metav1.ObjectMeta{Name: secret.Name, Namespace: secret.Namespace},
+			Spec:       v1alpha1.TokenRotationSpec{SecretName: secret.Name, Identity: identity},
+		}
+		// owned by the secret, so that it is garbage collected together with it
+		err = controllerutil.SetOwnerReference(secret, &rotation, r.GardenClient.Scheme())
+		if err == nil {
+			err = r.GardenClient.Create(ctx, &rotation)
+		}
+	}
+	if err != nil {
+		log.Error(err, "unable to get or create TokenRotation")
+		return
+	}
+
+	unmodifiedRotation := rotation.DeepCopy()
+	now := metav1.NewTime(Now())
+	status := &rotation.Status
+	if reason == reasonTokenRotated || reason == reasonTokenIssued {
+		status.LastRotationTime = &now
+	}
+	if reconcileErr == nil && result.RequeueAfter > 0 {
+		next := metav1.NewTime(now.Add(result.RequeueAfter))
+		status.NextRotationTime = &next
+	}
+	if expiresAt, err := time.Parse(time.RFC3339, secret.Annotations[r.AnnotationKeys.orDefault().TokenExpiresAt]); err == nil {
+		expiry := metav1.NewTime(expiresAt)
+		status.TokenExpiryTime = &expiry
+	}
+	condition := metav1.Condition{
+		Type:               v1alpha1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             string(reason),
+		LastTransitionTime: now,
+	}
+	if reconcileErr != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = string(reasonError)
+		condition.Message = reconcileErr.Error()
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+	if err := r.GardenClient.Status().Patch(ctx, &rotation, client.MergeFrom(unmodifiedRotation)); err != nil {
+		log.Error(err, "unable to update TokenRotation status")
+	}
+}
@@ -0,0 +1,129 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	"github.com/ironcore-dev/metal-token-rotate/api/v1alpha1"
+	"github.com/ironcore-dev/metal-token-rotate/controllers"
+)
+
+var _ = Describe("TokenRotation status", func() {
+
+	var (
+		r          *controllers.SecretReconciler
+		gardenFake client.Client
+		secretKey  client.ObjectKey
+		now        time.Time
+	)
+
+	BeforeEach(func() {
+		now = time.Now().Truncate(time.Second)
+		controllers.Now = func() time.Time { return now }
+		DeferCleanup(func() { controllers.Now = time.Now })
+
+		path := filepath.Join(GinkgoT().TempDir(), "config.json")
+		Expect(os.WriteFile(path, []byte(`{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"}]}`), 0644)).To(Succeed())
+		configWatcher, err := controllers.NewConfigWatcher(path, GinkgoLogr)
+		Expect(err).ToNot(HaveOccurred())
+
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(v1alpha1.AddToScheme(scheme)).To(Succeed())
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "metal-token",
+				Namespace:   "garden",
+				UID:         "secret-uid",
+				Annotations: map[string]string{controllers.AutoprovisionAnnotationKey: "cluster-a/metal"},
+			},
+		}
+		secretKey = client.ObjectKeyFromObject(secret)
+		gardenFake = fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithObjects(secret).
+			WithStatusSubresource(&v1alpha1.TokenRotation{}).
+			Build()
+		r = &controllers.SecretReconciler{
+			GardenClient:        gardenFake,
+			Log:                 GinkgoLogr,
+			ConfigWatcher:       configWatcher,
+			Recorder:            &record.FakeRecorder{},
+			TokenRotationStatus: true,
+		}
+	})
+
+	metalClient := func(token string, err error) client.Client {
+		return fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+			SubResourceCreate: func(ctx context.Context, c client.Client, subResourceName string, obj, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+				subResource.(*authenticationv1.TokenRequest).Status.Token = token
+				return err
+			},
+		}).Build()
+	}
+
+	It("records issued tokens", func(ctx SpecContext) {
+		r.LocalClient = metalClient(fakeToken(now, now.Add(time.Hour)), nil)
+		Expect(r.RotateNow(ctx, secretKey)).To(Succeed())
+
+		var rotation v1alpha1.TokenRotation
+		Expect(gardenFake.Get(ctx, secretKey, &rotation)).To(Succeed())
+		Expect(rotation.Spec).To(Equal(v1alpha1.TokenRotationSpec{SecretName: "metal-token", Identity: "cluster-a"}))
+		Expect(rotation.OwnerReferences).To(ConsistOf(HaveField("UID", BeEquivalentTo("secret-uid"))))
+		Expect(rotation.Status.LastRotationTime.Time).To(BeTemporally("==", now))
+		Expect(rotation.Status.TokenExpiryTime.Time).To(BeTemporally("==", now.Add(time.Hour)))
+		Expect(rotation.Status.NextRotationTime.Time).To(BeTemporally("~", now.Add(30*time.Minute), time.Minute))
+		Expect(rotation.Status.Conditions).To(ConsistOf(And(
+			HaveField("Type", v1alpha1.ConditionReady),
+			HaveField("Status", metav1.ConditionTrue),
+			HaveField("Reason", "TokenIssued"),
+		)))
+	})
+
+	It("records reconcile errors", func(ctx SpecContext) {
+		r.LocalClient = metalClient("", errors.New("metal cluster unavailable"))
+		Expect(r.RotateNow(ctx, secretKey)).ToNot(Succeed())
+
+		var rotation v1alpha1.TokenRotation
+		Expect(gardenFake.Get(ctx, secretKey, &rotation)).To(Succeed())
+		Expect(rotation.Status.LastRotationTime).To(BeNil())
+		Expect(rotation.Status.Conditions).To(ConsistOf(And(
+			HaveField("Type", v1alpha1.ConditionReady),
+			HaveField("Status", metav1.ConditionFalse),
+			HaveField("Reason", "Error"),
+			HaveField("Message", ContainSubstring("metal cluster unavailable")),
+		)))
+	})
+
+	It("does not record secrets without matching config", func(ctx SpecContext) {
+		var secret corev1.Secret
+		Expect(gardenFake.Get(ctx, secretKey, &secret)).To(Succeed())
+		secret.Annotations[controllers.AutoprovisionAnnotationKey] = "cluster-b/metal"
+		Expect(gardenFake.Update(ctx, &secret)).To(Succeed())
+		Expect(r.RotateNow(ctx, secretKey)).ToNot(Succeed())
+
+		var rotations v1alpha1.TokenRotationList
+		Expect(gardenFake.List(ctx, &rotations)).To(Succeed())
+		Expect(rotations.Items).To(BeEmpty())
+	})
+
+})
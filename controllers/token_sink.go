@@ -0,0 +1,106 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// IssuedToken is a token that replaces the current token of a managed secret.
+type IssuedToken struct {
+	// Key is the key of the secret the token belongs to.
+	Key            string
+	Token          string
+	Identity       string
+	ServiceAccount types.NamespacedName
+	// ExpiresAt is zero if the token is not a JWT.
+	ExpiresAt time.Time
+}
+
+// TokenSink stores issued tokens. StoreToken is called for every token before
+// the managed secret is patched, so that a failing sink makes the reconcile
+// fail before the token is handed out. Implementations must be safe for
+// concurrent use.
+type TokenSink interface {
+	StoreToken(ctx context.Context, secret *corev1.Secret, token IssuedToken) error
+}
+
+// SecretTokenSink writes tokens into the data of the managed secret, from
+// which the controller decides when to rotate them. It is always used.
+type SecretTokenSink struct {
+	// Base64 encodes tokens before writing them, see
+	// ClusterConfig.Base64EncodeTokens.
+	Base64 bool
+}
+
+// StoreToken implements TokenSink.
+func (s SecretTokenSink) StoreToken(_ context.Context, secret *corev1.Secret, token IssuedToken) error {
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	secret.Data[token.Key] = encodeToken(token.Token, s.Base64)
+	return nil
+}
+
+// encodeToken returns token as it is written into the secret data.
+func encodeToken(token string, base64Encode bool) []byte {
+	if !base64Encode {
+		return []byte(token)
+	}
+	return []byte(base64.StdEncoding.EncodeToString([]byte(token)))
+}
+
+// decodeToken returns the token stored in data by encodeToken. Values that
+// are not base64, e.g. tokens written before base64 encoding was enabled, are
+// returned as is together with the error.
+func decodeToken(data []byte, base64Encoded bool) (string, error) {
+	if !base64Encoded {
+		return string(data), nil
+	}
+	token, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return string(data), err
+	}
+	return string(token), nil
+}
+
+// CheckTokenSinks returns an error if a cluster of c names a token sink that
+// is not in sinks, the TokenSinks of the reconciler the config is used with.
+func (c *Config) CheckTokenSinks(sinks map[string]TokenSink) error {
+	for i, cluster := range c.Clusters {
+		for _, name := range cluster.TokenSinks {
+			if _, ok := sinks[name]; !ok {
+				return fmt.Errorf("invalid cluster at index %d: unknown token sink %q", i, name)
+			}
+		}
+	}
+	return nil
+}
+
+// storeToken passes token to the SecretTokenSink and then to the TokenSinks
+// named in the config. The other sinks always get the token as is.
+func (r *SecretReconciler) storeToken(ctx context.Context, secret *corev1.Secret, config *ClusterConfig, token IssuedToken) error {
+	if err := (SecretTokenSink{Base64: config.Base64EncodeTokens}).StoreToken(ctx, secret, token); err != nil {
+		return err
+	}
+	for _, name := range config.TokenSinks {
+		sink, ok := r.TokenSinks[name]
+		if !ok {
+			return fmt.Errorf("unknown token sink %q", name)
+		}
+		ctx, cancel := r.withClientTimeout(ctx)
+		err := sink.StoreToken(ctx, secret, token)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to store token in sink %q: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	"github.com/ironcore-dev/metal-token-rotate/controllers"
+)
+
+// fakeToken builds an unsigned JWT carrying the given iat and exp claims.
+func fakeToken(iat, exp time.Time) string {
+	return tokenWithPayload(fmt.Sprintf(`{"iat":%d,"exp":%d}`, iat.Unix(), exp.Unix()))
+}
+
+func tokenWithPayload(payload string) string {
+	return tokenWithEncoding(payload, base64.RawURLEncoding)
+}
+
+func tokenWithEncoding(payload string, encoding *base64.Encoding) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	return header + "." + encoding.EncodeToString([]byte(payload)) + ".signature"
+}
+
+// reviewingClient returns a fake metal client answering every TokenReview with authenticated.
+func reviewingClient(authenticated bool) client.Client {
+	return fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			if review, ok := obj.(*authenticationv1.TokenReview); ok {
+				review.Status.Authenticated = authenticated
+				return nil
+			}
+			return c.Create(ctx, obj, opts...)
+		},
+	}).Build()
+}
+
+// audienceReviewingClient returns a fake metal client authenticating tokens
+// for tokenAudiences, like an audience-aware API server.
+func audienceReviewingClient(tokenAudiences ...string) client.Client {
+	return fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			if review, ok := obj.(*authenticationv1.TokenReview); ok {
+				for _, audience := range review.Spec.Audiences {
+					if slices.Contains(tokenAudiences, audience) {
+						review.Status.Audiences = append(review.Status.Audiences, audience)
+					}
+				}
+				review.Status.Authenticated = len(review.Status.Audiences) > 0
+				return nil
+			}
+			return c.Create(ctx, obj, opts...)
+		},
+	}).Build()
+}
+
+// forbiddenReviewClient returns a fake metal client that is not allowed to create TokenReviews.
+func forbiddenReviewClient() client.Client {
+	return fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			if _, ok := obj.(*authenticationv1.TokenReview); ok {
+				return apierrors.NewForbidden(authenticationv1.Resource("tokenreviews"), "", errors.New("RBAC denied"))
+			}
+			return c.Create(ctx, obj, opts...)
+		},
+	}).Build()
+}
+
+var _ = Describe("RequeueAfter", func() {
+
+	var now time.Time
+
+	BeforeEach(func() {
+		now = time.Unix(1700000000, 0)
+		controllers.Now = func() time.Time { return now }
+		DeferCleanup(func() { controllers.Now = time.Now })
+	})
+
+	It("requeues when the renewal threshold is reached", func() {
+		token := fakeToken(now, now.Add(10*time.Minute))
+		Expect(controllers.RequeueAfter(token, 50, 0)).To(Equal(5 * time.Minute))
+		Expect(controllers.RequeueAfter(token, 80, 0)).To(Equal(8 * time.Minute))
+	})
+
+	It("requeues earlier by the clock skew tolerance", func() {
+		token := fakeToken(now, now.Add(10*time.Minute))
+		Expect(controllers.RequeueAfter(token, 50, 30*time.Second)).To(Equal(4*time.Minute + 30*time.Second))
+	})
+
+	It("does not requeue sooner than the floor", func() {
+		token := fakeToken(now.Add(-9*time.Minute), now.Add(time.Minute))
+		Expect(controllers.RequeueAfter(token, 50, 0)).To(Equal(30 * time.Second))
+	})
+
+	It("does not requeue later than the ceiling", func() {
+		token := fakeToken(now, now.Add(24*time.Hour))
+		Expect(controllers.RequeueAfter(token, 50, 0)).To(Equal(time.Hour))
+	})
+
+	It("does not requeue before the minimum rotation interval", func() {
+		token := fakeToken(now, now.Add(time.Hour))
+		Expect(controllers.RequeueAfterMinInterval(token, 1, 5*time.Minute)).To(Equal(5 * time.Minute))
+		Expect(controllers.RequeueAfterMinInterval(token, 50, 5*time.Minute)).To(Equal(30 * time.Minute))
+	})
+
+	It("falls back to the default for unparseable tokens", func() {
+		Expect(controllers.RequeueAfter("header.!!!.signature", 50, 0)).To(Equal(2 * time.Minute))
+	})
+
+})
+
+var _ = Describe("Requeue jitter", func() {
+
+	BeforeEach(func() {
+		controllers.RandFloat64 = func() float64 { return 0.5 }
+		DeferCleanup(func() { controllers.RandFloat64 = rand.Float64 })
+	})
+
+	It("adds up to the configured percentage", func() {
+		r := &controllers.SecretReconciler{RequeueJitterPercent: 10}
+		Expect(r.Jitter(2 * time.Minute)).To(Equal(2*time.Minute + 6*time.Second))
+	})
+
+	It("does not add jitter when disabled", func() {
+		r := &controllers.SecretReconciler{}
+		Expect(r.Jitter(2 * time.Minute)).To(Equal(2 * time.Minute))
+	})
+
+})
+
+var _ = Describe("needsToken", func() {
+
+	var (
+		now        time.Time
+		reconciler *controllers.SecretReconciler
+	)
+
+	BeforeEach(func() {
+		now = time.Unix(1700000000, 0)
+		controllers.Now = func() time.Time { return now }
+		DeferCleanup(func() { controllers.Now = time.Now })
+		reconciler = &controllers.SecretReconciler{Log: GinkgoLogr}
+	})
+
+	It("keeps a fresh token", func(ctx SpecContext) {
+		token := fakeToken(now.Add(-time.Minute), now.Add(9*time.Minute))
+		Expect(reconciler.NeedsToken(ctx, reviewingClient(true), token)).To(BeFalse())
+	})
+
+	It("rotates a token past the renewal threshold", func(ctx SpecContext) {
+		token := fakeToken(now.Add(-6*time.Minute), now.Add(4*time.Minute))
+		Expect(reconciler.NeedsToken(ctx, reviewingClient(true), token)).To(BeTrue())
+	})
+
+	It("rotates a token within the clock skew tolerance of the threshold", func(ctx SpecContext) {
+		token := fakeToken(now.Add(-290*time.Second), now.Add(310*time.Second))
+		Expect(reconciler.NeedsToken(ctx, reviewingClient(true), token)).To(BeFalse())
+		reconciler.ClockSkewTolerance = 30 * time.Second
+		Expect(reconciler.NeedsToken(ctx, reviewingClient(true), token)).To(BeTrue())
+	})
+
+	It("does not rotate a token before the minimum rotation interval", func(ctx SpecContext) {
+		// a renewal threshold of 50% of 10 minutes is crossed after 5 minutes
+		token := fakeToken(now.Add(-6*time.Minute), now.Add(4*time.Minute))
+		reconciler.MinRotationInterval = 7 * time.Minute
+		Expect(reconciler.NeedsToken(ctx, reviewingClient(true), token)).To(BeFalse())
+		reconciler.MinRotationInterval = 5 * time.Minute
+		Expect(reconciler.NeedsToken(ctx, reviewingClient(true), token)).To(BeTrue())
+	})
+
+	It("rotates an expired token despite the minimum rotation interval", func(ctx SpecContext) {
+		token := fakeToken(now.Add(-11*time.Minute), now.Add(-time.Minute))
+		reconciler.MinRotationInterval = time.Hour
+		Expect(reconciler.NeedsToken(ctx, reviewingClient(true), token)).To(BeTrue())
+	})
+
+	It("rotates a token that is not authenticated", func(ctx SpecContext) {
+		token := fakeToken(now.Add(-270*time.Second), now.Add(330*time.Second))
+		Expect(reconciler.NeedsToken(ctx, reviewingClient(false), token)).To(BeTrue())
+	})
+
+	It("does not review a token well within its renewal threshold", func(ctx SpecContext) {
+		token := fakeToken(now.Add(-230*time.Second), now.Add(370*time.Second))
+		Expect(reconciler.NeedsToken(ctx, reviewingClient(false), token)).To(BeFalse())
+	})
+
+	It("reviews a token issued in the future", func(ctx SpecContext) {
+		token := fakeToken(now.Add(time.Minute), now.Add(11*time.Minute))
+		Expect(reconciler.NeedsToken(ctx, reviewingClient(false), token)).To(BeTrue())
+	})
+
+	It("falls back to the token age when TokenReviews are forbidden", func(ctx SpecContext) {
+		fresh := fakeToken(now.Add(-time.Minute), now.Add(9*time.Minute))
+		Expect(reconciler.NeedsToken(ctx, forbiddenReviewClient(), fresh)).To(BeFalse())
+		old := fakeToken(now.Add(-6*time.Minute), now.Add(4*time.Minute))
+		Expect(reconciler.NeedsToken(ctx, forbiddenReviewClient(), old)).To(BeTrue())
+	})
+
+	DescribeTable("checks the audiences of reviewed tokens",
+		func(ctx SpecContext, metalClient client.Client, expected bool) {
+			token := fakeToken(now.Add(-270*time.Second), now.Add(330*time.Second))
+			Expect(reconciler.NeedsTokenForAudiences(ctx, metalClient, token, []string{"metal", "garden"})).To(Equal(expected))
+		},
+		Entry("keeps tokens for one of the audiences", audienceReviewingClient("garden"), false),
+		Entry("rotates tokens for other audiences", audienceReviewingClient("api"), true),
+		Entry("rotates tokens reviewed without audiences", reviewingClient(true), true),
+	)
+
+	It("defers rotation until the renew-after time", func(ctx SpecContext) {
+		tokenClient := &fakeTokenClient{}
+		reconciler.NewTokenClient = func(client.Client) controllers.TokenClient { return tokenClient }
+		token := fakeToken(now.Add(-6*time.Minute), now.Add(4*time.Minute))
+		Expect(reconciler.NeedsTokenRenewAfter(ctx, nil, token, now.Add(time.Minute))).To(BeFalse())
+		Expect(tokenClient.reviewed).To(BeEmpty())
+		tokenClient.authenticated = true
+		Expect(reconciler.NeedsTokenRenewAfter(ctx, nil, token, now.Add(-time.Minute))).To(BeTrue())
+	})
+
+	It("rotates an expired token despite the renew-after time", func(ctx SpecContext) {
+		token := fakeToken(now.Add(-11*time.Minute), now.Add(-time.Minute))
+		Expect(reconciler.NeedsTokenRenewAfter(ctx, reviewingClient(true), token, now.Add(time.Hour))).To(BeTrue())
+	})
+
+	It("rotates tokens older than maxTokenAge regardless of their expiry", func(ctx SpecContext) {
+		tokenClient := &fakeTokenClient{authenticated: true}
+		reconciler.NewTokenClient = func(client.Client) controllers.TokenClient { return tokenClient }
+		token := fakeToken(now.Add(-25*time.Hour), now.Add(30*24*time.Hour))
+		Expect(reconciler.NeedsTokenMaxAge(ctx, nil, token, 26*time.Hour, time.Time{})).To(BeFalse())
+		Expect(reconciler.NeedsTokenMaxAge(ctx, nil, token, 24*time.Hour, time.Time{})).To(BeTrue())
+		Expect(reconciler.NeedsTokenMaxAge(ctx, nil, token, 24*time.Hour, now.Add(time.Hour))).To(BeTrue())
+		Expect(tokenClient.reviewed).To(BeEmpty())
+	})
+
+	It("rotates a token that is not a JWT", func(ctx SpecContext) {
+		Expect(reconciler.NeedsToken(ctx, reviewingClient(true), "opaque-garbage-token")).To(BeTrue())
+	})
+
+	DescribeTable("rotates missing and garbled tokens without reviewing them",
+		func(ctx SpecContext, token func() string) {
+			tokenClient := &fakeTokenClient{authenticated: true}
+			reconciler.NewTokenClient = func(client.Client) controllers.TokenClient { return tokenClient }
+			Expect(reconciler.NeedsToken(ctx, nil, token())).To(BeTrue())
+			Expect(tokenClient.reviewed).To(BeEmpty())
+		},
+		Entry("empty", func() string { return "" }),
+		Entry("whitespace only", func() string { return " \n\t" }),
+		Entry("truncated", func() string { return fakeToken(now.Add(-270*time.Second), now.Add(330*time.Second))[:20] }),
+		Entry("with a trailing newline", func() string { return fakeToken(now.Add(-270*time.Second), now.Add(330*time.Second)) + "\n" }),
+	)
+
+	DescribeTable("decodes payloads in all base64 variants",
+		func(ctx SpecContext, encoding *base64.Encoding) {
+			// encodes to a padded segment containing '+' in the standard alphabet
+			payload := `{"iat":1699999940,"exp":1700000540,"sub":"~~~~~~~~~~~"}`
+			Expect(reconciler.NeedsToken(ctx, reviewingClient(true), tokenWithEncoding(payload, encoding))).To(BeFalse())
+		},
+		Entry("unpadded base64url", base64.RawURLEncoding),
+		Entry("padded base64url", base64.URLEncoding),
+		Entry("unpadded standard base64", base64.RawStdEncoding),
+		Entry("padded standard base64", base64.StdEncoding),
+	)
+
+	DescribeTable("rotates tokens with undecodable payloads",
+		func(ctx SpecContext, token string) {
+			Expect(reconciler.NeedsToken(ctx, reviewingClient(true), token)).To(BeTrue())
+		},
+		Entry("invalid base64", "header.!!!.signature"),
+		Entry("invalid JSON", tokenWithPayload(`{"iat":`)),
+	)
+
+	DescribeTable("rotates tokens without usable time claims",
+		func(ctx SpecContext, payload string) {
+			Expect(reconciler.NeedsToken(ctx, reviewingClient(true), tokenWithPayload(payload))).To(BeTrue())
+		},
+		Entry("missing iat", `{"exp":1700000600}`),
+		Entry("missing exp", `{"iat":1700000000}`),
+		Entry("missing iat and exp", `{}`),
+		Entry("exp equal to iat", `{"iat":1700000000,"exp":1700000000}`),
+		Entry("exp before iat", `{"iat":1700000600,"exp":1700000000}`),
+	)
+
+})
+
+var _ = Describe("LifetimeDiverges", func() {
+
+	It("tolerates small differences", func() {
+		Expect(controllers.LifetimeDiverges(time.Hour, time.Hour)).To(BeFalse())
+		Expect(controllers.LifetimeDiverges(time.Hour, 55*time.Minute)).To(BeFalse())
+	})
+
+	It("detects capped lifetimes", func() {
+		Expect(controllers.LifetimeDiverges(24*time.Hour, time.Hour)).To(BeTrue())
+	})
+
+})
@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// AutoprovisionValidator is a validating admission webhook for secrets. It
+// rejects autoprovision annotations that the controller would skip, so that
+// users find out when they write the secret instead of waiting for a token.
+type AutoprovisionValidator struct {
+	ConfigWatcher *ConfigWatcher
+	// AnnotationKeys defaults to DefaultAnnotationKeys.
+	AnnotationKeys AnnotationKeys
+}
+
+var _ admission.CustomValidator = &AutoprovisionValidator{}
+
+// SetupWebhookWithManager registers the webhook at /validate--v1-secret of
+// the manager's webhook server.
+func (v *AutoprovisionValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&corev1.Secret{}).
+		WithValidator(v).
+		Complete()
+}
+
+// ValidateCreate implements admission.CustomValidator.
+func (v *AutoprovisionValidator) ValidateCreate(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	secret, ok := obj.(*corev1.Secret)
+	if !ok {
+		return nil, fmt.Errorf("expected a Secret but got %T", obj)
+	}
+	return nil, v.validate(secret)
+}
+
+// ValidateUpdate implements admission.CustomValidator. Only changes of the
+// annotation are validated, so that secrets whose identity was removed from
+// the config can still be updated, e.g. to remove the finalizer.
+func (v *AutoprovisionValidator) ValidateUpdate(_ context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	oldSecret, ok := oldObj.(*corev1.Secret)
+	if !ok {
+		return nil, fmt.Errorf("expected a Secret but got %T", oldObj)
+	}
+	secret, ok := newObj.(*corev1.Secret)
+	if !ok {
+		return nil, fmt.Errorf("expected a Secret but got %T", newObj)
+	}
+	key := v.AnnotationKeys.orDefault().Autoprovision
+	if !secret.DeletionTimestamp.IsZero() || secret.Annotations[key] == oldSecret.Annotations[key] {
+		return nil, nil
+	}
+	return nil, v.validate(secret)
+}
+
+// ValidateDelete implements admission.CustomValidator.
+func (v *AutoprovisionValidator) ValidateDelete(context.Context, runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *AutoprovisionValidator) validate(secret *corev1.Secret) error {
+	key := v.AnnotationKeys.orDefault().Autoprovision
+	value, ok := secret.Annotations[key]
+	if !ok {
+		return nil
+	}
+	target, err := parseAutoprovisionValue(value)
+	if err == nil {
+		_, err = target.resolveNamespaces(secret)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s annotation: %w", key, err)
+	}
+	config := v.ConfigWatcher.Config()
+	if _, ok := config.Cluster(target.identity); !ok {
+		return fmt.Errorf("invalid %s annotation: unknown identity %q", key, target.identity)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/ironcore-dev/metal-token-rotate/controllers"
+)
+
+var _ = Describe("AutoprovisionValidator", func() {
+
+	var validator *controllers.AutoprovisionValidator
+
+	BeforeEach(func() {
+		path := filepath.Join(GinkgoT().TempDir(), "config.json")
+		Expect(os.WriteFile(path, []byte(`{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"}]}`), 0644)).To(Succeed())
+		configWatcher, err := controllers.NewConfigWatcher(path, GinkgoLogr)
+		Expect(err).ToNot(HaveOccurred())
+		validator = &controllers.AutoprovisionValidator{ConfigWatcher: configWatcher}
+	})
+
+	secretWithAnnotation := func(value string) *corev1.Secret {
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "metal-token", Namespace: "garden", Labels: map[string]string{"team": "blue"}}}
+		if value != "" {
+			secret.Annotations = map[string]string{controllers.AutoprovisionAnnotationKey: value}
+		}
+		return secret
+	}
+
+	DescribeTable("validates created secrets",
+		func(ctx SpecContext, value, expectedErr string) {
+			_, err := validator.ValidateCreate(ctx, secretWithAnnotation(value))
+			if expectedErr == "" {
+				Expect(err).ToNot(HaveOccurred())
+			} else {
+				Expect(err).To(MatchError(ContainSubstring(expectedErr)))
+			}
+		},
+		Entry("accepts secrets without annotation", "", ""),
+		Entry("accepts known identities", "cluster-a/metal", ""),
+		Entry("accepts templates", `cluster-a/metal-{{ label "team" }}`, ""),
+		Entry("rejects malformed annotations", "cluster-a", "invalid metal.ironcore.dev/autoprovision annotation"),
+		Entry("rejects templates that cannot be rendered", `cluster-a/metal-{{ label "owner" }}`, `secret has no label "owner"`),
+		Entry("rejects unknown identities", "cluster-b/metal", `unknown identity "cluster-b"`),
+	)
+
+	It("validates updates that change the annotation", func(ctx SpecContext) {
+		_, err := validator.ValidateUpdate(ctx, secretWithAnnotation("cluster-a/metal"), secretWithAnnotation("cluster-b/metal"))
+		Expect(err).To(MatchError(ContainSubstring(`unknown identity "cluster-b"`)))
+	})
+
+	It("accepts updates that keep the annotation", func(ctx SpecContext) {
+		_, err := validator.ValidateUpdate(ctx, secretWithAnnotation("cluster-b/metal"), secretWithAnnotation("cluster-b/metal"))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("validates the configured annotation key", func(ctx SpecContext) {
+		keys, err := controllers.NewAnnotationKeys("tokens.example.com")
+		Expect(err).ToNot(HaveOccurred())
+		validator.AnnotationKeys = keys
+		_, err = validator.ValidateCreate(ctx, secretWithAnnotation("cluster-b/metal"))
+		Expect(err).ToNot(HaveOccurred())
+
+		secret := secretWithAnnotation("")
+		secret.Annotations = map[string]string{keys.Autoprovision: "cluster-b/metal"}
+		_, err = validator.ValidateCreate(ctx, secret)
+		Expect(err).To(MatchError(`invalid tokens.example.com/autoprovision annotation: unknown identity "cluster-b"`))
+	})
+
+	It("accepts updates of deleted secrets", func(ctx SpecContext) {
+		secret := secretWithAnnotation("cluster-b/metal")
+		now := metav1.Now()
+		secret.DeletionTimestamp = &now
+		_, err := validator.ValidateUpdate(ctx, secretWithAnnotation(""), secret)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+})
@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+)
+
+// eventFlushTimeout bounds how long the one-shot commands wait for their
+// events to be written before they exit.
+const eventFlushTimeout = 10 * time.Second
+
+// newEventRecorder returns a recorder writing events to the cluster of config,
+// like the manager's recorder does for the controller, and a function waiting
+// until the recorded events are written. The one-shot commands call it before
+// they exit, since the broadcaster drops pending events on shutdown.
+func newEventRecorder(config *rest.Config) (record.EventRecorder, func(), error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create event client: %w", err)
+	}
+	recorder, flush := newSinkEventRecorder(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	return recorder, flush, nil
+}
+
+// newSinkEventRecorder returns a recorder writing events to sink and a
+// function waiting until the recorded events are written.
+func newSinkEventRecorder(sink record.EventSink) (record.EventRecorder, func()) {
+	broadcaster := record.NewBroadcaster()
+	recorder := &pendingEventRecorder{
+		recorder: broadcaster.NewRecorder(scheme, corev1.EventSource{Component: "metal-token-rotate"}),
+	}
+	broadcaster.StartEventWatcher(func(event *corev1.Event) {
+		defer recorder.pending.Done()
+		if _, err := sink.Create(event); err != nil {
+			setupLog.Error(err, "unable to write event", "reason", event.Reason, "object", event.InvolvedObject.Namespace+"/"+event.InvolvedObject.Name)
+		}
+	})
+	flush := func() {
+		done := make(chan struct{})
+		go func() {
+			recorder.pending.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(eventFlushTimeout):
+			setupLog.Info("WARNING: not all events were written before exiting", "timeout", eventFlushTimeout)
+		}
+		broadcaster.Shutdown()
+	}
+	return recorder, flush
+}
+
+// pendingEventRecorder counts the events that have not been written yet.
+type pendingEventRecorder struct {
+	recorder record.EventRecorder
+	pending  sync.WaitGroup
+}
+
+// Event implements record.EventRecorder.
+func (r *pendingEventRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	r.pending.Add(1)
+	r.recorder.Event(object, eventtype, reason, message)
+}
+
+// Eventf implements record.EventRecorder.
+func (r *pendingEventRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...any) {
+	r.pending.Add(1)
+	r.recorder.Eventf(object, eventtype, reason, messageFmt, args...)
+}
+
+// AnnotatedEventf implements record.EventRecorder.
+func (r *pendingEventRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...any) {
+	r.pending.Add(1)
+	r.recorder.AnnotatedEventf(object, annotations, eventtype, reason, messageFmt, args...)
+}
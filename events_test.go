@@ -0,0 +1,49 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// recordingEventSink records created events.
+type recordingEventSink struct {
+	mu      sync.Mutex
+	reasons []string
+}
+
+func (s *recordingEventSink) Create(event *corev1.Event) (*corev1.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reasons = append(s.reasons, event.Reason)
+	return event, nil
+}
+
+func (s *recordingEventSink) Update(event *corev1.Event) (*corev1.Event, error) {
+	return event, nil
+}
+
+func (s *recordingEventSink) Patch(event *corev1.Event, _ []byte) (*corev1.Event, error) {
+	return event, nil
+}
+
+var _ = Describe("newSinkEventRecorder", func() {
+
+	It("writes all recorded events before flush returns", func() {
+		sink := &recordingEventSink{}
+		recorder, flush := newSinkEventRecorder(sink)
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "metal-token", Namespace: "garden"}}
+
+		recorder.Event(secret, corev1.EventTypeNormal, "TokenRotated", "rotated")
+		recorder.Eventf(secret, corev1.EventTypeWarning, "TokenLifetimeMismatch", "valid for %s", "10m0s")
+		flush()
+		Expect(sink.reasons).To(Equal([]string{"TokenRotated", "TokenLifetimeMismatch"}))
+	})
+
+})
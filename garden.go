@@ -0,0 +1,225 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	certutil "k8s.io/client-go/util/cert"
+)
+
+// gardenRetryBaseDelay and gardenRetryMaxDelay bound the backoff while
+// waiting for the garden cluster at startup.
+var (
+	gardenRetryBaseDelay = time.Second
+	gardenRetryMaxDelay  = 30 * time.Second
+)
+
+// gardenOptions describe how the garden cluster is reached.
+type gardenOptions struct {
+	// Address is the URL of the garden API server.
+	Address string
+	// TokenFile contains the bearer token, it is re-read by client-go.
+	TokenFile string
+	// RootCAFile contains the CA bundle, it is re-read for new connections.
+	// Defaults to defaultGardenRootCAFile.
+	RootCAFile string
+	// InsecureSkipTLSVerify disables the verification of the garden API
+	// server's certificate, for local testing only.
+	InsecureSkipTLSVerify bool
+}
+
+// restConfig returns the config for clients of the garden cluster.
+func (o gardenOptions) restConfig() (*rest.Config, error) {
+	if o.Address == "" {
+		return nil, errors.New("garden api address is not set, use --garden-address or GARDEN_CLUSTER_ADDRESS")
+	}
+
+	// fail fast if the token is not there, but let client-go read it: it
+	// re-reads BearerTokenFile periodically, so tokens refreshed by the kubelet
+	// are picked up by all clients sharing the manager's transport
+	if _, err := os.ReadFile(o.TokenFile); err != nil {
+		return nil, fmt.Errorf("failed to read garden token: %w", err)
+	}
+
+	if o.InsecureSkipTLSVerify {
+		if o.RootCAFile != "" {
+			return nil, errors.New("--garden-insecure-skip-tls-verify cannot be combined with --garden-ca-file")
+		}
+		setupLog.Info("WARNING: not verifying the certificate of the garden cluster, never do this in production", "address", o.Address)
+		return &rest.Config{
+			Host:            o.Address,
+			BearerTokenFile: o.TokenFile,
+			TLSClientConfig: rest.TLSClientConfig{Insecure: true},
+		}, nil
+	}
+	rootCAFile := o.RootCAFile
+	if rootCAFile == "" {
+		rootCAFile = defaultGardenRootCAFile
+	}
+
+	host, err := addressHost(o.Address)
+	if err != nil {
+		return nil, err
+	}
+	// the CA bundle is re-read for new connections, so it can be rotated without a restart
+	caReloader, err := newCAReloader(rootCAFile, host)
+	if err != nil {
+		return nil, fmt.Errorf("expected to load root CA config from %s, but got err: %w", rootCAFile, err)
+	}
+
+	return &rest.Config{
+		Host:            o.Address,
+		Transport:       caReloader.transport(),
+		BearerTokenFile: o.TokenFile,
+	}, nil
+}
+
+// addressHost returns the host name or IP address of the garden API address,
+// which may omit the scheme like the Host of a rest.Config.
+func addressHost(address string) (string, error) {
+	if !strings.Contains(address, "://") {
+		address = "https://" + address
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid garden api address: %w", err)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("garden api address %s has no host", address)
+	}
+	return u.Hostname(), nil
+}
+
+// caReloader verifies server certificates against a CA bundle that is re-read
+// from disk for every new connection, so that a rotated bundle is picked up
+// without a restart. The last bundle that could be loaded is kept if the file
+// is temporarily unreadable.
+type caReloader struct {
+	path string
+	// host is checked against the server certificate. It is not taken from
+	// the connection, whose ServerName is empty for IP addresses, which would
+	// skip the check.
+	host string
+
+	mu   sync.Mutex
+	pool *x509.CertPool
+}
+
+func newCAReloader(path, host string) (*caReloader, error) {
+	r := &caReloader{path: path, host: host}
+	if _, err := r.certPool(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *caReloader) certPool() (*x509.CertPool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pool, err := certutil.NewPool(r.path)
+	if err != nil {
+		if r.pool != nil {
+			setupLog.Error(err, "failed to reload garden CA bundle, using the previous one")
+			return r.pool, nil
+		}
+		return nil, err
+	}
+	r.pool = pool
+	return pool, nil
+}
+
+func (r *caReloader) verifyConnection(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("server did not present a certificate")
+	}
+	pool, err := r.certPool()
+	if err != nil {
+		return err
+	}
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		DNSName:       r.host,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range state.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err = state.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// transport returns an HTTP transport verifying servers with the reloaded CA bundle.
+func (r *caReloader) transport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// the default verification uses a static pool, verifyConnection does it instead
+		InsecureSkipVerify: true, //nolint:gosec // certificates are verified in verifyConnection
+		VerifyConnection:   r.verifyConnection,
+	}
+	return transport
+}
+
+// gardenVersionCheck returns a check requesting the version of the garden
+// cluster, which succeeds as soon as its API server is up.
+func gardenVersionCheck(config *rest.Config) (func(context.Context) error, error) {
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create garden discovery client: %w", err)
+	}
+	return func(ctx context.Context) error {
+		return discoveryClient.RESTClient().Get().AbsPath("/version").Do(ctx).Error()
+	}, nil
+}
+
+// waitForGarden runs check with exponential backoff until it succeeds, so that
+// a garden cluster that is still coming up does not make the controller
+// crash-loop. It gives up after timeout, or right away if the API server
+// answered with an error that retrying does not fix, like Unauthorized.
+func waitForGarden(ctx context.Context, check func(context.Context) error, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	delay := gardenRetryBaseDelay
+	for {
+		err := check(ctx)
+		if err == nil {
+			return nil
+		}
+		if !isTransientGardenError(err) {
+			return fmt.Errorf("garden cluster is misconfigured: %w", err)
+		}
+		setupLog.Info("garden cluster is not reachable yet, retrying", "error", err.Error(), "retryAfter", delay)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("garden cluster did not become reachable within %s: %w", timeout, err)
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, gardenRetryMaxDelay)
+	}
+}
+
+// isTransientGardenError reports whether err is a connection error or an
+// error status of an API server that is not ready yet.
+func isTransientGardenError(err error) bool {
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) {
+		return true
+	}
+	return apierrors.IsServiceUnavailable(err) || apierrors.IsInternalError(err) ||
+		apierrors.IsTimeout(err) || apierrors.IsServerTimeout(err) || apierrors.IsTooManyRequests(err)
+}
@@ -0,0 +1,251 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/rest"
+	certutil "k8s.io/client-go/util/cert"
+)
+
+var _ = Describe("caReloader", func() {
+
+	writeServerCA := func(path string, server *httptest.Server) {
+		data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+		Expect(os.WriteFile(path, data, 0644)).To(Succeed())
+	}
+
+	It("verifies new connections against the current CA bundle", func(ctx SpecContext) {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+		DeferCleanup(server.Close)
+		otherCA, _, err := certutil.GenerateSelfSignedCertKey("other-ca", nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		caFile := filepath.Join(GinkgoT().TempDir(), "bundle.crt")
+		Expect(os.WriteFile(caFile, otherCA, 0644)).To(Succeed())
+		reloader, err := newCAReloader(caFile, "127.0.0.1")
+		Expect(err).ToNot(HaveOccurred())
+		httpClient := &http.Client{Transport: reloader.transport()}
+
+		get := func() error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, http.NoBody)
+			Expect(err).ToNot(HaveOccurred())
+			resp, err := httpClient.Do(req)
+			if err != nil {
+				return err
+			}
+			return resp.Body.Close()
+		}
+
+		Expect(get()).To(MatchError(ContainSubstring("certificate signed by unknown authority")))
+		writeServerCA(caFile, server)
+		Expect(get()).To(Succeed())
+	})
+
+	It("rejects certificates for other hosts of servers addressed by IP", func(ctx SpecContext) {
+		cert, key, err := certutil.GenerateSelfSignedCertKey("garden.example.com", nil, []string{"garden.example.com"})
+		Expect(err).ToNot(HaveOccurred())
+		keyPair, err := tls.X509KeyPair(cert, key)
+		Expect(err).ToNot(HaveOccurred())
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+		server.TLS = &tls.Config{Certificates: []tls.Certificate{keyPair}}
+		server.StartTLS()
+		DeferCleanup(server.Close)
+
+		caFile := filepath.Join(GinkgoT().TempDir(), "bundle.crt")
+		Expect(os.WriteFile(caFile, cert, 0644)).To(Succeed())
+		host, err := addressHost(server.URL)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(host).To(Equal("127.0.0.1"))
+		reloader, err := newCAReloader(caFile, host)
+		Expect(err).ToNot(HaveOccurred())
+		httpClient := &http.Client{Transport: reloader.transport()}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, http.NoBody)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = httpClient.Do(req)
+		Expect(err).To(MatchError(ContainSubstring("cannot validate certificate for 127.0.0.1")))
+	})
+
+	It("keeps the previous CA bundle if the file cannot be read", func() {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+		DeferCleanup(server.Close)
+
+		caFile := filepath.Join(GinkgoT().TempDir(), "bundle.crt")
+		writeServerCA(caFile, server)
+		reloader, err := newCAReloader(caFile, "127.0.0.1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(os.Remove(caFile)).To(Succeed())
+
+		pool, err := reloader.certPool()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pool).ToNot(BeNil())
+	})
+
+})
+
+var _ = DescribeTable("addressHost",
+	func(address, expected string) {
+		Expect(addressHost(address)).To(Equal(expected))
+	},
+	Entry("takes the host of a URL", "https://garden.example.com:443", "garden.example.com"),
+	Entry("takes IP addresses", "https://10.0.0.1", "10.0.0.1"),
+	Entry("takes IPv6 addresses", "https://[fd00::1]:6443", "fd00::1"),
+	Entry("accepts addresses without scheme", "garden.example.com:443", "garden.example.com"),
+)
+
+var _ = Describe("gardenAddress", func() {
+
+	It("prefers the flag over the env var", func() {
+		GinkgoT().Setenv("GARDEN_CLUSTER_ADDRESS", "https://env.example.com")
+		Expect(gardenAddress("https://flag.example.com")).To(Equal("https://flag.example.com"))
+	})
+
+	It("falls back to the env var", func() {
+		GinkgoT().Setenv("GARDEN_CLUSTER_ADDRESS", "https://env.example.com")
+		Expect(gardenAddress("")).To(Equal("https://env.example.com"))
+	})
+
+	It("fails the garden config if neither is set", func() {
+		GinkgoT().Setenv("GARDEN_CLUSTER_ADDRESS", "")
+		_, err := gardenOptions{Address: gardenAddress(""), TokenFile: "token", RootCAFile: "bundle.crt"}.restConfig()
+		Expect(err).To(MatchError("garden api address is not set, use --garden-address or GARDEN_CLUSTER_ADDRESS"))
+	})
+
+})
+
+var _ = Describe("gardenOptions", func() {
+
+	var options gardenOptions
+
+	BeforeEach(func() {
+		dir := GinkgoT().TempDir()
+		ca, _, err := certutil.GenerateSelfSignedCertKey("garden-ca", nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+		options = gardenOptions{
+			Address:    "https://garden.example.com",
+			TokenFile:  filepath.Join(dir, "token"),
+			RootCAFile: filepath.Join(dir, "bundle.crt"),
+		}
+		Expect(os.WriteFile(options.TokenFile, []byte("garden-token"), 0600)).To(Succeed())
+		Expect(os.WriteFile(options.RootCAFile, ca, 0644)).To(Succeed())
+	})
+
+	It("returns a config reading the token file", func() {
+		config, err := options.restConfig()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.Host).To(Equal("https://garden.example.com"))
+		Expect(config.BearerTokenFile).To(Equal(options.TokenFile))
+		Expect(config.BearerToken).To(BeEmpty())
+		Expect(config.Transport).ToNot(BeNil())
+	})
+
+	It("fails without token file", func() {
+		Expect(os.Remove(options.TokenFile)).To(Succeed())
+		_, err := options.restConfig()
+		Expect(err).To(MatchError(And(ContainSubstring("failed to read garden token"), ContainSubstring("no such file or directory"))))
+	})
+
+	It("fails without CA bundle", func() {
+		Expect(os.Remove(options.RootCAFile)).To(Succeed())
+		_, err := options.restConfig()
+		Expect(err).To(MatchError(ContainSubstring("expected to load root CA config from " + options.RootCAFile)))
+	})
+
+	It("skips TLS verification only if asked to", func() {
+		options.RootCAFile = ""
+		options.InsecureSkipTLSVerify = true
+		config, err := options.restConfig()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.Insecure).To(BeTrue())
+		Expect(config.Transport).To(BeNil())
+		Expect(config.BearerTokenFile).To(Equal(options.TokenFile))
+	})
+
+	It("refuses to skip TLS verification with a CA bundle", func() {
+		options.InsecureSkipTLSVerify = true
+		_, err := options.restConfig()
+		Expect(err).To(MatchError("--garden-insecure-skip-tls-verify cannot be combined with --garden-ca-file"))
+	})
+
+	It("fails with an invalid CA bundle", func() {
+		Expect(os.WriteFile(options.RootCAFile, []byte("not a certificate"), 0644)).To(Succeed())
+		_, err := options.restConfig()
+		Expect(err).To(MatchError(ContainSubstring("expected to load root CA config from " + options.RootCAFile)))
+	})
+
+})
+
+var _ = Describe("waitForGarden", func() {
+
+	BeforeEach(func() {
+		gardenRetryBaseDelay, gardenRetryMaxDelay = time.Millisecond, time.Millisecond
+		DeferCleanup(func() {
+			gardenRetryBaseDelay, gardenRetryMaxDelay = time.Second, 30*time.Second
+		})
+	})
+
+	// failingCheck fails the first failures calls with err.
+	failingCheck := func(failures int, err error) (func(context.Context) error, *int) {
+		calls := 0
+		return func(context.Context) error {
+			calls++
+			if calls <= failures {
+				return err
+			}
+			return nil
+		}, &calls
+	}
+
+	It("waits until the garden cluster is reachable", func(ctx SpecContext) {
+		check, calls := failingCheck(3, errors.New("connection refused"))
+		Expect(waitForGarden(ctx, check, time.Minute)).To(Succeed())
+		Expect(*calls).To(Equal(4))
+	})
+
+	It("waits while the API server is unavailable", func(ctx SpecContext) {
+		check, calls := failingCheck(1, apierrors.NewServiceUnavailable("starting"))
+		Expect(waitForGarden(ctx, check, time.Minute)).To(Succeed())
+		Expect(*calls).To(Equal(2))
+	})
+
+	It("gives up after the timeout", func(ctx SpecContext) {
+		check, _ := failingCheck(math.MaxInt, errors.New("connection refused"))
+		Expect(waitForGarden(ctx, check, 20*time.Millisecond)).To(MatchError("garden cluster did not become reachable within 20ms: connection refused"))
+	})
+
+	It("fails right away if the garden cluster is misconfigured", func(ctx SpecContext) {
+		check, calls := failingCheck(math.MaxInt, apierrors.NewUnauthorized("invalid token"))
+		Expect(waitForGarden(ctx, check, time.Minute)).To(MatchError("garden cluster is misconfigured: invalid token"))
+		Expect(*calls).To(Equal(1))
+	})
+
+	It("checks the version endpoint", func(ctx SpecContext) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/version" {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write([]byte(`{"major":"1","minor":"33"}`))
+		}))
+		DeferCleanup(server.Close)
+		check, err := gardenVersionCheck(&rest.Config{Host: server.URL})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(check(ctx)).To(Succeed())
+	})
+
+})
@@ -4,50 +4,155 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"go.uber.org/zap/zapcore"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
-	certutil "k8s.io/client-go/util/cert"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	ctrlconfig "sigs.k8s.io/controller-runtime/pkg/client/config"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
+	"github.com/ironcore-dev/metal-token-rotate/api/v1alpha1"
 	"github.com/ironcore-dev/metal-token-rotate/controllers"
 )
 
+const (
+	defaultGardenTokenFile  = "/var/run/garden/auth/token" //nolint:gosec
+	defaultGardenRootCAFile = "/var/run/garden/auth/bundle.crt"
+)
+
 var (
 	scheme   = runtime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
+	// tokenSinks are the sinks cluster configs can name in tokenSinks. This
+	// binary has none, programs embedding the controller pass their own.
+	tokenSinks map[string]controllers.TokenSink
 )
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
 }
 
 func main() {
-	var kubecontext string
-	opts := zap.Options{
-		Development: true,
-		TimeEncoder: zapcore.ISO8601TimeEncoder,
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(runValidate(os.Args[2:], os.Stdout, os.Stderr))
+		case "rotate":
+			os.Exit(runRotate(ctrl.SetupSignalHandler(), os.Args[2:], os.Stderr))
+		}
 	}
+	runController()
+}
+
+// runController runs the controller until it receives SIGTERM or SIGINT.
+func runController() {
+	var kubecontext string
+	var gardenAddr string
+	var configPath string
+	var configMapRef string
+	var configMapCluster string
+	var gardenTokenFile string
+	var gardenRootCAFile string
+	var gardenInsecure bool
+	var gardenStartupTimeout time.Duration
+	var metricsAddr string
+	var probeAddr string
+	var leaderElect bool
+	var leaderElectionNamespace string
+	var requeueJitterPercent int64
+	var maxConcurrentReconciles int
+	var clockSkewTolerance time.Duration
+	var minRotationInterval time.Duration
+	var retryBaseDelay time.Duration
+	var retryMaxDelay time.Duration
+	var namespaces string
+	var resyncOnConfigChange bool
+	var syncPeriod time.Duration
+	var defaultRequeue time.Duration
+	var clientTimeout time.Duration
+	var auditSinkName string
+	var enableWebhook bool
+	var webhookPort int
+	var webhookCertDir string
+	var once bool
+	var tokenRotationStatus bool
+	var annotationPrefix string
+	var preflight bool
+	opts := zapOptions()
 	flag.StringVar(&kubecontext, "kubecontext", "", "The context to use from the kubeconfig (defaults to current-context)")
+	flag.StringVar(&configPath, "config", controllers.DefaultConfigPath, "The config file, or a directory whose JSON and YAML files are merged into the config")
+	flag.StringVar(&configMapRef, "config-configmap", "", "Load and watch the config from the ConfigMap <namespace>/<name> instead of --config")
+	flag.StringVar(&configMapCluster, "config-configmap-cluster", "local", `The cluster containing the --config-configmap: "local" or "garden"`)
+	flag.StringVar(&gardenAddr, "garden-address", "", "The API server address of the garden cluster (defaults to the GARDEN_CLUSTER_ADDRESS env var)")
+	flag.StringVar(&gardenTokenFile, "garden-token-file", defaultGardenTokenFile, "The file containing the token for the garden cluster")
+	flag.StringVar(&gardenRootCAFile, "garden-ca-file", "", "The file containing the CA bundle of the garden cluster (defaults to "+defaultGardenRootCAFile+")")
+	flag.BoolVar(&gardenInsecure, "garden-insecure-skip-tls-verify", false, "Do not verify the certificate of the garden cluster, for local testing only")
+	flag.DurationVar(&gardenStartupTimeout, "garden-startup-timeout", 5*time.Minute, "How long to wait on startup for the garden cluster to become reachable (use 0 to not wait)")
+	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metrics endpoint binds to (use 0 to disable)")
+	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to")
+	flag.BoolVar(&leaderElect, "leader-elect", false, "Enable leader election in the garden cluster to allow running multiple replicas")
+	flag.StringVar(&leaderElectionNamespace, "leader-election-namespace", "", "The garden cluster namespace for the leader election lease (defaults to the pod namespace)")
+	flag.Int64Var(&requeueJitterPercent, "requeue-jitter-percent", 10, "Maximum random delay added to requeues, as a percentage of the requeue interval")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1, "The number of secrets that are reconciled in parallel")
+	flag.DurationVar(&clockSkewTolerance, "clock-skew-tolerance", 30*time.Second, "How much earlier tokens are rotated to account for clock differences with the metal cluster")
+	flag.DurationVar(&minRotationInterval, "min-rotation-interval", controllers.DefaultMinRotationInterval, "The shortest token age at which tokens are rotated, unless they expire earlier (use 0 for no limit)")
+	flag.DurationVar(&retryBaseDelay, "retry-base-delay", time.Second, "Initial delay before retrying a failed reconcile")
+	flag.DurationVar(&retryMaxDelay, "retry-max-delay", 5*time.Minute, "Maximum delay before retrying a failed reconcile")
+	flag.StringVar(&namespaces, "namespaces", "", "Comma-separated list of garden namespaces to watch secrets in (defaults to all namespaces)")
+	flag.BoolVar(&resyncOnConfigChange, "resync-on-config-change", true, "Reconcile all annotated secrets when the config changes")
+	flag.DurationVar(&syncPeriod, "sync-period", 10*time.Minute, "How often all secrets are reconciled, even without changes")
+	flag.DurationVar(&defaultRequeue, "default-requeue", controllers.DefaultRequeueAfter, "How often secrets are reconciled whose token expiry cannot be determined")
+	flag.DurationVar(&clientTimeout, "client-timeout", controllers.DefaultClientTimeout, "Timeout for each call to the garden and metal clusters made while reconciling")
+	flag.StringVar(&auditSinkName, "audit-sink", "", `Where to record issued tokens: "stdout" for JSON lines on stdout (disabled by default)`)
+	flag.BoolVar(&enableWebhook, "enable-webhook", false, "Serve a validating admission webhook for autoprovision annotations of garden secrets")
+	flag.IntVar(&webhookPort, "webhook-port", webhook.DefaultPort, "The port the webhook server listens on")
+	flag.StringVar(&webhookCertDir, "webhook-cert-dir", "", "The directory containing tls.crt and tls.key of the webhook server (defaults to <temp dir>/k8s-webhook-server/serving-certs)")
+	flag.BoolVar(&once, "once", false, "Reconcile all annotated secrets once and exit instead of running the controller, e.g. in a CronJob")
+	flag.BoolVar(&tokenRotationStatus, "token-rotation-status", false, "Maintain a TokenRotation with the state of each managed secret, which requires the TokenRotation CRD in the garden cluster")
+	flag.StringVar(&annotationPrefix, "annotation-prefix", controllers.DefaultAnnotationPrefix, "The prefix of the annotation keys on garden secrets, e.g. <prefix>/autoprovision")
+	flag.BoolVar(&preflight, "preflight", false, "Check on startup that the metal cluster of every configured cluster is reachable and allows requesting tokens, and exit if not")
 	opts.BindFlags(flag.CommandLine)
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	auditSink, err := newAuditSink(auditSinkName)
+	if err != nil {
+		setupLog.Error(err, "invalid --audit-sink")
+		os.Exit(1)
+	}
+	annotationKeys, err := controllers.NewAnnotationKeys(annotationPrefix)
+	if err != nil {
+		setupLog.Error(err, "invalid --annotation-prefix")
+		os.Exit(1)
+	}
 	localConfig := getKubeconfigOrDie(kubecontext)
 	setupLog.Info("loaded local kubeconfig", "context", kubecontext, "host", localConfig.Host)
 
-	gardenClusterAddress := os.Getenv("GARDEN_CLUSTER_ADDRESS")
-	gardenConfig, err := gardenClusterConfig(gardenClusterAddress)
+	gardenConfig, err := gardenOptions{
+		Address:               gardenAddress(gardenAddr),
+		TokenFile:             gardenTokenFile,
+		RootCAFile:            gardenRootCAFile,
+		InsecureSkipTLSVerify: gardenInsecure,
+	}.restConfig()
 	if err != nil {
 		setupLog.Error(err, "Failed to load garden cluster config")
 		os.Exit(1)
@@ -57,74 +162,280 @@ func main() {
 		setupLog.Error(err, "Failed to create garden client")
 		os.Exit(1)
 	}
+	ctx := ctrl.SetupSignalHandler()
+	if gardenStartupTimeout > 0 {
+		check, err := gardenVersionCheck(gardenConfig)
+		if err == nil {
+			err = waitForGarden(ctx, check, gardenStartupTimeout)
+		}
+		if err != nil {
+			setupLog.Error(err, "garden cluster is not reachable")
+			os.Exit(1)
+		}
+	}
+	configWatcher, err := newConfigWatcher(ctx, configPath, configMapRef, configMapCluster, localConfig, gardenConfig)
+	if err == nil {
+		err = configWatcher.Config().CheckTokenSinks(tokenSinks)
+	}
+	if err != nil {
+		setupLog.Error(err, "unable to load config")
+		os.Exit(1)
+	}
+	if once {
+		os.Exit(runOnce(ctx, configWatcher, &controllers.SecretReconciler{
+			LocalClient:         localClient,
+			LocalConfig:         localConfig,
+			Log:                 ctrl.Log.WithName("controllers").WithName("secret"),
+			ClockSkewTolerance:  clockSkewTolerance,
+			MinRotationInterval: minRotationInterval,
+			ClientTimeout:       clientTimeout,
+			AuditSink:           auditSink,
+			TokenSinks:          tokenSinks,
+			TokenRotationStatus: tokenRotationStatus,
+			AnnotationKeys:      annotationKeys,
+		}, gardenConfig, namespaces))
+	}
 
 	mgr, err := ctrl.NewManager(gardenConfig, ctrl.Options{
-		Scheme:         scheme,
-		LeaderElection: false,
+		Scheme:                  scheme,
+		LeaderElection:          leaderElect,
+		LeaderElectionID:        "metal-token-rotate.metal.ironcore.dev",
+		LeaderElectionNamespace: leaderElectionNamespace,
+		Metrics:                 metricsserver.Options{BindAddress: metricsAddr},
+		HealthProbeBindAddress:  probeAddr,
+		WebhookServer:           webhook.NewServer(webhook.Options{Port: webhookPort, CertDir: webhookCertDir}),
+		// resyncs deliver every cached secret to the controller again, so that
+		// missed events cannot delay a rotation for longer than syncPeriod
+		Cache: cache.Options{
+			DefaultNamespaces: cacheNamespaces(namespaces),
+			SyncPeriod:        &syncPeriod,
+		},
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to setup manager")
 		os.Exit(1)
 	}
 
+	if err = mgr.Add(configWatcher); err != nil {
+		setupLog.Error(err, "unable to add config watcher")
+		os.Exit(1)
+	}
+
+	// watches target kubeconfig secrets, reads still go through localClient.
+	// The cache is restricted to the namespaces of the target secrets, so
+	// that it neither needs access to nor holds all secrets of the cluster.
+	// The process restarts when a reloaded config changes these namespaces.
+	var localCluster cluster.Cluster
+	targetNamespaces := targetSecretNamespaces(configWatcher.Config())
+	if err = mgr.Add(newTargetNamespaceWatch(configWatcher, targetNamespaces)); err != nil {
+		setupLog.Error(err, "unable to add target namespace watch")
+		os.Exit(1)
+	}
+	if targetNamespaces != nil {
+		localCluster, err = cluster.New(localConfig, func(o *cluster.Options) {
+			o.Scheme = scheme
+			o.Cache.DefaultNamespaces = targetNamespaces
+		})
+		if err != nil {
+			setupLog.Error(err, "unable to set up local cluster")
+			os.Exit(1)
+		}
+		if err = mgr.Add(localCluster); err != nil {
+			setupLog.Error(err, "unable to add local cluster")
+			os.Exit(1)
+		}
+	}
+
 	secretController := controllers.SecretReconciler{
-		GardenClient: mgr.GetClient(),
-		LocalClient:  localClient,
-		Log:          ctrl.Log.WithName("controllers").WithName("secret"),
-		ConfigPath:   controllers.DefaultConfigPath,
+		GardenClient:            mgr.GetClient(),
+		LocalClient:             localClient,
+		LocalConfig:             localConfig,
+		LocalCluster:            localCluster,
+		Log:                     ctrl.Log.WithName("controllers").WithName("secret"),
+		ConfigWatcher:           configWatcher,
+		RequeueJitterPercent:    requeueJitterPercent,
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+		ClockSkewTolerance:      clockSkewTolerance,
+		MinRotationInterval:     minRotationInterval,
+		RetryBaseDelay:          retryBaseDelay,
+		RetryMaxDelay:           retryMaxDelay,
+		DefaultRequeue:          defaultRequeue,
+		ResyncOnConfigChange:    resyncOnConfigChange,
+		ClientTimeout:           clientTimeout,
+		AuditSink:               auditSink,
+		TokenSinks:              tokenSinks,
+		TokenRotationStatus:     tokenRotationStatus,
+		AnnotationKeys:          annotationKeys,
 	}
 	if err = secretController.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Secret")
 		os.Exit(1)
 	}
 
+	if preflight {
+		if err := secretController.Preflight(ctx); err != nil {
+			setupLog.Error(err, "preflight check failed")
+			os.Exit(1)
+		}
+	}
+
+	if enableWebhook {
+		validator := controllers.AutoprovisionValidator{ConfigWatcher: configWatcher, AnnotationKeys: annotationKeys}
+		if err = validator.SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Secret")
+			os.Exit(1)
+		}
+		if err := mgr.AddReadyzCheck("webhook", mgr.GetWebhookServer().StartedChecker()); err != nil {
+			setupLog.Error(err, "unable to set up webhook ready check")
+			os.Exit(1)
+		}
+	}
+
+	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up health check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("readyz", readyzCheck(configFilePath(configPath, configMapRef), gardenTokenFile)); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
+
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	if err := mgr.Start(ctx); err != nil {
+		if errors.Is(err, errTargetSecretNamespacesChanged) {
+			// exits with an error, so that the process is restarted
+			setupLog.Info("stopped manager to watch the new namespaces of target kubeconfig secrets")
+			os.Exit(1)
+		}
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
 	setupLog.Info("received SIGTERM or SIGINT. See you later.")
 }
 
-func getKubeconfigOrDie(kubecontext string) *rest.Config {
-	if kubecontext == "" {
-		kubecontext = os.Getenv("KUBECONTEXT")
+// cacheNamespaces turns the --namespaces flag into the namespaces watched by
+// the manager cache. A nil map makes the cache watch all namespaces.
+func cacheNamespaces(value string) map[string]cache.Config {
+	var namespaces map[string]cache.Config
+	for namespace := range strings.SplitSeq(value, ",") {
+		namespace = strings.TrimSpace(namespace)
+		if namespace == "" {
+			continue
+		}
+		if namespaces == nil {
+			namespaces = make(map[string]cache.Config)
+		}
+		namespaces[namespace] = cache.Config{}
+	}
+	return namespaces
+}
+
+// newAuditSink returns the sink named by the --audit-sink flag, or nil if
+// issued tokens are not recorded.
+func newAuditSink(name string) (controllers.AuditSink, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case "stdout":
+		return controllers.NewJSONLinesAuditSink(os.Stdout), nil
+	default:
+		return nil, fmt.Errorf("unknown audit sink %q", name)
 	}
-	restConfig, err := ctrlconfig.GetConfigWithContext(kubecontext)
+}
+
+// newConfigWatcher loads the config from the ConfigMap given by
+// --config-configmap, or from configPath if that flag is unset.
+func newConfigWatcher(ctx context.Context, configPath, configMapRef, configMapCluster string, localConfig, gardenConfig *rest.Config) (*controllers.ConfigWatcher, error) {
+	log := ctrl.Log.WithName("config")
+	if configMapRef == "" {
+		return controllers.NewConfigWatcher(configPath, log)
+	}
+	configMap, err := parseConfigMapRef(configMapRef)
 	if err != nil {
-		setupLog.Error(err, "Failed to load kubeconfig")
-		os.Exit(1)
+		return nil, err
 	}
-	return restConfig
+	var restConfig *rest.Config
+	switch configMapCluster {
+	case "local":
+		restConfig = localConfig
+	case "garden":
+		restConfig = gardenConfig
+	default:
+		return nil, fmt.Errorf(`invalid --config-configmap-cluster %q, expected "local" or "garden"`, configMapCluster)
+	}
+	c, err := client.NewWithWatch(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create config map client: %w", err)
+	}
+	return controllers.NewConfigMapWatcher(ctx, c, configMap, log)
+}
+
+// parseConfigMapRef parses the <namespace>/<name> value of --config-configmap.
+func parseConfigMapRef(value string) (types.NamespacedName, error) {
+	namespace, name, ok := strings.Cut(value, "/")
+	if !ok || namespace == "" || name == "" || strings.Contains(name, "/") {
+		return types.NamespacedName{}, fmt.Errorf("invalid --config-configmap %q, expected <namespace>/<name>", value)
+	}
+	return types.NamespacedName{Namespace: namespace, Name: name}, nil
 }
 
-func gardenClusterConfig(apiAddress string) (*rest.Config, error) {
-	const (
-		tokenFile  = "/var/run/garden/auth/token" //nolint:gosec
-		rootCAFile = "/var/run/garden/auth/bundle.crt"
-	)
+// configFilePath returns the config file checked for readiness, which is
+// none if the config is loaded from a ConfigMap.
+func configFilePath(configPath, configMapRef string) string {
+	if configMapRef != "" {
+		return ""
+	}
+	return configPath
+}
 
-	if apiAddress == "" {
-		return nil, errors.New("garden api address is empty")
+// zapOptions returns the logging options of the controller and its
+// subcommands, which log in production mode unless --zap-devel is given.
+func zapOptions() zap.Options {
+	return zap.Options{
+		TimeEncoder: zapcore.ISO8601TimeEncoder,
 	}
+}
 
-	token, err := os.ReadFile(tokenFile)
-	if err != nil {
-		return nil, err
+// getKubeconfig loads the kubeconfig of the local cluster for kubecontext, or
+// for the KUBECONTEXT env var if kubecontext is empty.
+func getKubeconfig(kubecontext string) (*rest.Config, error) {
+	if kubecontext == "" {
+		kubecontext = os.Getenv("KUBECONTEXT")
 	}
+	return ctrlconfig.GetConfigWithContext(kubecontext)
+}
 
-	tlsClientConfig := rest.TLSClientConfig{}
+func getKubeconfigOrDie(kubecontext string) *rest.Config {
+	restConfig, err := getKubeconfig(kubecontext)
+	if err != nil {
+		setupLog.Error(err, "Failed to load kubeconfig")
+		os.Exit(1)
+	}
+	return restConfig
+}
 
-	if _, err := certutil.NewPool(rootCAFile); err != nil {
-		return nil, fmt.Errorf("expected to load root CA config from %s, but got err: %w", rootCAFile, err)
-	} else {
-		tlsClientConfig.CAFile = rootCAFile
+// readyzCheck fails if the garden token cannot be read or the config on disk
+// is invalid. The config is not checked if configPath is empty.
+func readyzCheck(configPath, gardenTokenFile string) healthz.Checker {
+	return func(_ *http.Request) error {
+		if _, err := os.ReadFile(gardenTokenFile); err != nil {
+			return fmt.Errorf("garden token file is not readable: %w", err)
+		}
+		if configPath == "" {
+			return nil
+		}
+		if _, err := controllers.LoadConfig(configPath); err != nil {
+			return err
+		}
+		return nil
 	}
+}
 
-	return &rest.Config{
-		Host:            apiAddress,
-		TLSClientConfig: tlsClientConfig,
-		BearerToken:     string(token),
-		BearerTokenFile: tokenFile,
-	}, nil
+// gardenAddress returns the garden API address given by the --garden-address
+// flag, or by the GARDEN_CLUSTER_ADDRESS env var if the flag is unset.
+func gardenAddress(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	return os.Getenv("GARDEN_CLUSTER_ADDRESS")
 }
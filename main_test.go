@@ -0,0 +1,74 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+
+	"github.com/ironcore-dev/metal-token-rotate/controllers"
+)
+
+var _ = Describe("cacheNamespaces", func() {
+
+	It("watches all namespaces by default", func() {
+		Expect(cacheNamespaces("")).To(BeNil())
+		Expect(cacheNamespaces(" , ")).To(BeNil())
+	})
+
+	It("watches the given namespaces", func() {
+		Expect(cacheNamespaces("garden-a, garden-b,")).To(Equal(map[string]cache.Config{
+			"garden-a": {},
+			"garden-b": {},
+		}))
+	})
+
+})
+
+var _ = Describe("newAuditSink", func() {
+
+	It("disables auditing by default", func() {
+		Expect(newAuditSink("")).To(BeNil())
+	})
+
+	It("writes to stdout", func() {
+		Expect(newAuditSink("stdout")).To(BeAssignableToTypeOf(&controllers.JSONLinesAuditSink{}))
+	})
+
+	It("rejects unknown sinks", func() {
+		_, err := newAuditSink("configmap")
+		Expect(err).To(MatchError(`unknown audit sink "configmap"`))
+	})
+
+})
+
+var _ = Describe("parseConfigMapRef", func() {
+
+	It("parses namespace and name", func() {
+		Expect(parseConfigMapRef("garden/metal-token-rotate")).To(Equal(types.NamespacedName{Namespace: "garden", Name: "metal-token-rotate"}))
+	})
+
+	DescribeTable("rejects invalid references",
+		func(value string) {
+			_, err := parseConfigMapRef(value)
+			Expect(err).To(MatchError(`invalid --config-configmap "` + value + `", expected <namespace>/<name>`))
+		},
+		Entry("without namespace", "metal-token-rotate"),
+		Entry("with an empty namespace", "/metal-token-rotate"),
+		Entry("with an empty name", "garden/"),
+		Entry("with too many segments", "garden/metal/token"),
+	)
+
+})
+
+var _ = Describe("newConfigWatcher", func() {
+
+	It("rejects unknown clusters", func(ctx SpecContext) {
+		_, err := newConfigWatcher(ctx, "", "garden/metal-token-rotate", "metal", nil, nil)
+		Expect(err).To(MatchError(`invalid --config-configmap-cluster "metal", expected "local" or "garden"`))
+	})
+
+})
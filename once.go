@@ -0,0 +1,43 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"maps"
+	"slices"
+
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/ironcore-dev/metal-token-rotate/controllers"
+)
+
+// runOnce reconciles all annotated secrets in the given garden namespaces
+// once, without the manager and its cache, and returns the exit code. The
+// reconciler must have its local cluster fields set.
+func runOnce(ctx context.Context, configWatcher *controllers.ConfigWatcher, reconciler *controllers.SecretReconciler, gardenConfig *rest.Config, namespaces string) int {
+	gardenClient, err := client.New(gardenConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		setupLog.Error(err, "unable to create garden client")
+		return 1
+	}
+	recorder, flushEvents, err := newEventRecorder(gardenConfig)
+	if err != nil {
+		setupLog.Error(err, "unable to create event recorder")
+		return 1
+	}
+	defer flushEvents()
+	reconciler.GardenClient = gardenClient
+	reconciler.ConfigWatcher = configWatcher
+	reconciler.Recorder = recorder
+
+	reasons, err := reconciler.ReconcileAll(ctx, slices.Sorted(maps.Keys(cacheNamespaces(namespaces)))...)
+	setupLog.Info("reconciled all annotated secrets", "reasons", reasons)
+	if err != nil {
+		setupLog.Error(err, "some secrets failed to reconcile")
+		return 1
+	}
+	return 0
+}
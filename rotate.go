@@ -0,0 +1,114 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/ironcore-dev/metal-token-rotate/controllers"
+)
+
+// runRotate implements the rotate subcommand, which replaces the tokens of a
+// single secret once using the same clients as the controller. It returns the
+// exit code.
+func runRotate(ctx context.Context, args []string, stderr io.Writer) int {
+	flags := flag.NewFlagSet("rotate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", controllers.DefaultConfigPath, "The config file, or a directory whose JSON and YAML files are merged into the config")
+	kubecontext := flags.String("kubecontext", "", "The context to use from the kubeconfig (defaults to current-context)")
+	gardenAddr := flags.String("garden-address", "", "The API server address of the garden cluster (defaults to the GARDEN_CLUSTER_ADDRESS env var)")
+	gardenTokenFile := flags.String("garden-token-file", defaultGardenTokenFile, "The file containing the token for the garden cluster")
+	gardenRootCAFile := flags.String("garden-ca-file", "", "The file containing the CA bundle of the garden cluster (defaults to "+defaultGardenRootCAFile+")")
+	gardenInsecure := flags.Bool("garden-insecure-skip-tls-verify", false, "Do not verify the certificate of the garden cluster, for local testing only")
+	namespace := flags.String("namespace", "", "The namespace of the secret to rotate")
+	name := flags.String("name", "", "The name of the secret to rotate")
+	auditSinkName := flags.String("audit-sink", "", `Where to record issued tokens: "stdout" for JSON lines on stdout (disabled by default)`)
+	annotationPrefix := flags.String("annotation-prefix", controllers.DefaultAnnotationPrefix, "The prefix of the annotation keys on garden secrets, e.g. <prefix>/autoprovision")
+	opts := zapOptions()
+	opts.BindFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *namespace == "" || *name == "" {
+		fmt.Fprintln(stderr, "--namespace and --name are required")
+		return 2
+	}
+	auditSink, err := newAuditSink(*auditSinkName)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	annotationKeys, err := controllers.NewAnnotationKeys(*annotationPrefix)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+
+	if err := rotate(ctx, *configPath, *kubecontext, gardenOptions{
+		Address:               gardenAddress(*gardenAddr),
+		TokenFile:             *gardenTokenFile,
+		RootCAFile:            *gardenRootCAFile,
+		InsecureSkipTLSVerify: *gardenInsecure,
+	}, auditSink, annotationKeys, types.NamespacedName{Namespace: *namespace, Name: *name}); err != nil {
+		setupLog.Error(err, "unable to rotate secret")
+		return 1
+	}
+	setupLog.Info("rotated secret", "namespace", *namespace, "name", *name)
+	return 0
+}
+
+func rotate(ctx context.Context, configPath, kubecontext string, garden gardenOptions, auditSink controllers.AuditSink, annotationKeys controllers.AnnotationKeys, secret types.NamespacedName) error {
+	configWatcher, err := controllers.NewConfigWatcher(configPath, ctrl.Log.WithName("config"))
+	if err != nil {
+		return fmt.Errorf("unable to load config: %w", err)
+	}
+	if err := configWatcher.Config().CheckTokenSinks(tokenSinks); err != nil {
+		return fmt.Errorf("unable to load config: %w", err)
+	}
+	localConfig, err := getKubeconfig(kubecontext)
+	if err != nil {
+		return fmt.Errorf("unable to load kubeconfig: %w", err)
+	}
+	localClient, err := client.New(localConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return fmt.Errorf("unable to create local client: %w", err)
+	}
+	gardenConfig, err := garden.restConfig()
+	if err != nil {
+		return fmt.Errorf("unable to load garden cluster config: %w", err)
+	}
+	gardenClient, err := client.New(gardenConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return fmt.Errorf("unable to create garden client: %w", err)
+	}
+	// events go to the garden cluster like those of the controller, since
+	// they refer to the garden secret
+	recorder, flushEvents, err := newEventRecorder(gardenConfig)
+	if err != nil {
+		return err
+	}
+	defer flushEvents()
+
+	reconciler := controllers.SecretReconciler{
+		GardenClient:   gardenClient,
+		LocalClient:    localClient,
+		LocalConfig:    localConfig,
+		Log:            ctrl.Log.WithName("rotate"),
+		ConfigWatcher:  configWatcher,
+		Recorder:       recorder,
+		AuditSink:      auditSink,
+		TokenSinks:     tokenSinks,
+		AnnotationKeys: annotationKeys,
+	}
+	return reconciler.RotateNow(ctx, secret)
+}
@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+
+	"github.com/ironcore-dev/metal-token-rotate/controllers"
+)
+
+// errTargetSecretNamespacesChanged stops the manager, so that the restarted
+// process watches the target secrets of the new config.
+var errTargetSecretNamespacesChanged = errors.New("namespaces of target kubeconfig secrets changed")
+
+// targetSecretNamespaces returns the namespaces of the target kubeconfig
+// secrets of config for the cache of the local cluster, or nil if there are none.
+func targetSecretNamespaces(config *controllers.Config) map[string]cache.Config {
+	var namespaces map[string]cache.Config
+	for _, cluster := range config.Clusters {
+		if cluster.TargetSecretNamespace == "" {
+			continue
+		}
+		if namespaces == nil {
+			namespaces = make(map[string]cache.Config)
+		}
+		namespaces[cluster.TargetSecretNamespace] = cache.Config{}
+	}
+	return namespaces
+}
+
+// targetNamespaceWatch compares the target secret namespaces of every
+// reloaded config with the namespaces watched by the local cluster cache,
+// which cannot be changed once it is started.
+type targetNamespaceWatch struct {
+	configWatcher *controllers.ConfigWatcher
+	watched       map[string]cache.Config
+	changes       chan struct{}
+}
+
+func newTargetNamespaceWatch(configWatcher *controllers.ConfigWatcher, watched map[string]cache.Config) *targetNamespaceWatch {
+	w := &targetNamespaceWatch{configWatcher: configWatcher, watched: watched, changes: make(chan struct{}, 1)}
+	configWatcher.Notify(w.changes)
+	return w
+}
+
+// Start implements manager.Runnable. It returns errTargetSecretNamespacesChanged
+// once the namespaces of the config differ from the watched ones.
+func (w *targetNamespaceWatch) Start(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-w.changes:
+			namespaces := targetSecretNamespaces(w.configWatcher.Config())
+			if maps.EqualFunc(namespaces, w.watched, func(cache.Config, cache.Config) bool { return true }) {
+				continue
+			}
+			setupLog.Info("namespaces of target kubeconfig secrets changed, restarting to watch them",
+				"watched", slices.Sorted(maps.Keys(w.watched)), "configured", slices.Sorted(maps.Keys(namespaces)))
+			return errTargetSecretNamespacesChanged
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. Every replica
+// watches target secrets, not just the leader.
+func (w *targetNamespaceWatch) NeedLeaderElection() bool {
+	return false
+}
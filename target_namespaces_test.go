@@ -0,0 +1,84 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+
+	"github.com/ironcore-dev/metal-token-rotate/controllers"
+)
+
+var _ = Describe("targetSecretNamespaces", func() {
+
+	It("does not watch without target secrets", func() {
+		Expect(targetSecretNamespaces(&controllers.Config{
+			Clusters: []controllers.ClusterConfig{{Identity: "garden"}},
+		})).To(BeNil())
+	})
+
+	It("watches the namespaces of the target secrets", func() {
+		Expect(targetSecretNamespaces(&controllers.Config{
+			Clusters: []controllers.ClusterConfig{
+				{Identity: "garden"},
+				{Identity: "garden-a", TargetSecretNamespace: "kubeconfigs"},
+				{Identity: "garden-b", TargetSecretNamespace: "kubeconfigs"},
+				{Identity: "garden-c", TargetSecretNamespace: "other"},
+			},
+		})).To(Equal(map[string]cache.Config{
+			"kubeconfigs": {},
+			"other":       {},
+		}))
+	})
+
+})
+
+var _ = Describe("targetNamespaceWatch", func() {
+
+	const (
+		configA  = `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"garden-a","targetSecretName":"kubeconfig","targetSecretNamespace":"kubeconfigs"}]}`
+		configAB = `{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"garden-a","targetSecretName":"kubeconfig","targetSecretNamespace":"kubeconfigs"},` +
+			`{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"garden-b","targetSecretName":"kubeconfig","targetSecretNamespace":"other"}]}`
+	)
+
+	var (
+		path   string
+		result chan error
+	)
+
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "config.json")
+		Expect(os.WriteFile(path, []byte(configA), 0644)).To(Succeed())
+		configWatcher, err := controllers.NewConfigWatcher(path, GinkgoLogr)
+		Expect(err).ToNot(HaveOccurred())
+		watch := newTargetNamespaceWatch(configWatcher, targetSecretNamespaces(configWatcher.Config()))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+		go func() {
+			defer GinkgoRecover()
+			Expect(configWatcher.Start(ctx)).To(Succeed())
+		}()
+		result = make(chan error, 1)
+		go func() {
+			result <- watch.Start(ctx)
+		}()
+	})
+
+	It("keeps running while the namespaces stay the same", func() {
+		Expect(os.WriteFile(path, []byte(configA+"\n"), 0644)).To(Succeed())
+		Consistently(result).ShouldNot(Receive())
+	})
+
+	It("stops when a reloaded config adds a namespace", func() {
+		Expect(os.WriteFile(path, []byte(configAB), 0644)).To(Succeed())
+		Eventually(result).Should(Receive(MatchError(errTargetSecretNamespacesChanged)))
+	})
+
+})
@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ironcore-dev/metal-token-rotate/controllers"
+)
+
+// runValidate implements the validate subcommand. It returns the exit code.
+func runValidate(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", controllers.DefaultConfigPath, "The config file or directory to validate")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	config, err := controllers.LoadConfig(*configPath)
+	if err == nil {
+		err = config.CheckTokenSinks(tokenSinks)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%s is invalid: %s\n", *configPath, err)
+		return 1
+	}
+	if err := printConfigSummary(stdout, *configPath, config); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// printConfigSummary prints the clusters of config with their defaults applied.
+func printConfigSummary(w io.Writer, path string, config controllers.Config) error {
+	fmt.Fprintf(w, "%s is valid (%s), %d cluster(s)\n\n", path, config.APIVersion, len(config.Clusters))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "IDENTITY\tSERVICE ACCOUNT\tEXPIRATION\tRENEWAL\tMETAL CLUSTER\tKEYS")
+	for _, cluster := range config.Clusters {
+		metalCluster := "local"
+		if cluster.TargetSecretName != "" {
+			metalCluster = cluster.TargetSecretNamespace + "/" + cluster.TargetSecretName
+		}
+		renewal := fmt.Sprintf("%d%%", cluster.RenewalThresholdPercent)
+		if cluster.RenewalJitterPercent > 0 {
+			renewal = fmt.Sprintf("%d±%d%%", cluster.RenewalThresholdPercent, cluster.RenewalJitterPercent)
+		}
+		keys := []string{cluster.SecretKeys.TokenKey}
+		for _, token := range cluster.AdditionalTokens {
+			keys = append(keys, token.TokenKey)
+		}
+		fmt.Fprintf(tw, "%s\t%s/%s\t%ds\t%s\t%s\t%s\n",
+			cluster.Identity,
+			cluster.ServiceAccountNamespace, cluster.ServiceAccountName,
+			cluster.ExpirationSeconds,
+			renewal,
+			metalCluster,
+			strings.Join(keys, ","),
+		)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if warnings := config.Warnings(); len(warnings) > 0 {
+		fmt.Fprintln(w)
+		for _, warning := range warnings {
+			fmt.Fprintf(w, "WARNING: %s\n", warning)
+		}
+	}
+	return nil
+}
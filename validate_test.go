@@ -0,0 +1,63 @@
+// SPDX-FileCopyrightText: 2025 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("the validate subcommand", func() {
+
+	var stdout, stderr *bytes.Buffer
+
+	BeforeEach(func() {
+		stdout = &bytes.Buffer{}
+		stderr = &bytes.Buffer{}
+	})
+
+	writeConfig := func(content string) string {
+		path := filepath.Join(GinkgoT().TempDir(), "config.json")
+		Expect(os.WriteFile(path, []byte(content), 0644)).To(Succeed())
+		return path
+	}
+
+	It("prints a summary of a valid config", func() {
+		path := writeConfig(`{"items":[
+			{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a"},
+			{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-b","targetSecretName":"kubeconfig","targetSecretNamespace":"metal","renewalThresholdPercent":80}
+		]}`)
+		Expect(runValidate([]string{"--config", path}, stdout, stderr)).To(Equal(0))
+		Expect(stderr.String()).To(BeEmpty())
+		Expect(stdout.String()).To(Equal(path + ` is valid (metal-token-rotate.ironcore.dev/v1), 2 cluster(s)
+
+IDENTITY   SERVICE ACCOUNT  EXPIRATION  RENEWAL  METAL CLUSTER     KEYS
+cluster-a  ns/sa            3600s       50%      local             token
+cluster-b  ns/sa            3600s       80%      metal/kubeconfig  token
+
+WARNING: clusters at index 0 and 1 use the same service account ns/sa
+`))
+	})
+
+	It("fails for an invalid config", func() {
+		path := writeConfig(`{"items":[{"serviceAccountName":"sa","identity":"cluster-a"}]}`)
+		Expect(runValidate([]string{"--config", path}, stdout, stderr)).To(Equal(1))
+		Expect(stderr.String()).To(ContainSubstring("serviceAccountNamespace is required"))
+	})
+
+	It("fails for token sinks that are not registered", func() {
+		path := writeConfig(`{"items":[{"serviceAccountName":"sa","serviceAccountNamespace":"ns","identity":"cluster-a","tokenSinks":["vault"]}]}`)
+		Expect(runValidate([]string{"--config", path}, stdout, stderr)).To(Equal(1))
+		Expect(stderr.String()).To(ContainSubstring(`unknown token sink "vault"`))
+	})
+
+	It("fails for unknown flags", func() {
+		Expect(runValidate([]string{"--unknown"}, stdout, stderr)).To(Equal(2))
+	})
+
+})